@@ -0,0 +1,240 @@
+package main
+
+import "math"
+
+// By default a drink is absorbed instantly: its whole amount is in the
+// blood when it is logged and then decays exponentially. Setting
+// Config.AbsorptionMinutes switches to a two-compartment model, where
+// caffeine first moves from the gut into the blood with first-order rate
+// ka = ln 2 / absorption half-life, and is eliminated from the blood with
+// ke = ln 2 / elimination half-life (the Bateman function):
+//
+//	C(t) = D * ka / (ka - ke) * (e^(-ke t) - e^(-ka t))
+//
+// A drink taken on an empty stomach is absorbed faster and hits harder. In
+// either model its effective dose D is multiplied by emptyStomachDoseFactor
+// (1.1: a 10% higher peak and total exposure). In the two-compartment model
+// its absorption half-life is also multiplied by
+// emptyStomachAbsorptionFactor (0.5: the ramp up takes half as long); with
+// instant absorption there is no ramp to shorten.
+//
+// Override events (see override.go) always take effect instantly and decay
+// exponentially, whatever the model.
+//
+// Config.DecayModel picks how the tail of each drink's curve decays:
+//   - "exponential" (the default): the curve above all the way down.
+//   - "linear-tail": once a drink's level has peaked and dropped to
+//     Config.TailCrossoverMg, it falls in a straight line at the
+//     elimination rate it had there, ke * TailCrossoverMg mg/h, reaching
+//     zero 1/ke hours (about 1.44 half-lives) later. With instant
+//     absorption the line is the tangent at the crossover, so both the
+//     level and its slope are continuous; in the two-compartment model
+//     only the level is. A drink that never reaches the crossover starts
+//     its line at its peak.
+const (
+	emptyStomachAbsorptionFactor = 0.5
+	emptyStomachDoseFactor       = 1.1
+
+	decayExponential = "exponential"
+	decayLinearTail  = "linear-tail"
+)
+
+// decayModels lists the accepted values of Config.DecayModel.
+var decayModels = []string{decayExponential, decayLinearTail}
+
+// effectiveDose returns how much of event's amount reaches the blood: more
+// on an empty stomach. Overrides are calibrations and count as stated.
+func effectiveDose(event CoffeeIntakeEvent) float64 {
+	if event.EmptyStomach && !event.Override {
+		return event.Amount * emptyStomachDoseFactor
+	}
+	return event.Amount
+}
+
+// absorptionModel returns the effective dose and the absorption and
+// elimination rate constants (per hour) of an event, or ok=false if the
+// event is absorbed instantly.
+func absorptionModel(event CoffeeIntakeEvent, config Config) (dose, ka, ke float64, ok bool) {
+	if config.AbsorptionMinutes <= 0 || event.Override {
+		return 0, 0, 0, false
+	}
+	absorptionHalfLife := config.AbsorptionMinutes / 60
+	if event.EmptyStomach {
+		absorptionHalfLife *= emptyStomachAbsorptionFactor
+	}
+	return effectiveDose(event), math.Ln2 / absorptionHalfLife, math.Ln2 / config.HalfLifeFor(event.Type), true
+}
+
+// eventLevel returns how much caffeine from event is in the blood the given
+// number of hours after it was logged. Hours asleep are converted to
+// metabolic hours first (see sleepdecay.go).
+func eventLevel(event CoffeeIntakeEvent, hours float64, config Config) float64 {
+	if hours < 0 {
+		return 0
+	}
+	hours = metabolicHours(event, hours, config)
+	if config.DecayModel == decayLinearTail && !event.Override {
+		crossover, start, rate := linearTail(event, config)
+		if hours > crossover {
+			return math.Max(start-rate*(hours-crossover), 0)
+		}
+	}
+	return curveLevel(event, hours, config)
+}
+
+// curveLevel is eventLevel without a linear tail.
+func curveLevel(event CoffeeIntakeEvent, hours float64, config Config) float64 {
+	dose, ka, ke, ok := absorptionModel(event, config)
+	if !ok {
+		// Caffeine decay formula: C = C0 * (0.5)^(t / T_half)
+		return effectiveDose(event) * math.Pow(0.5, hours/config.HalfLifeFor(event.Type))
+	}
+	if ka == ke {
+		return dose * ka * hours * math.Exp(-ke*hours)
+	}
+	return dose * ka / (ka - ke) * (math.Exp(-ke*hours) - math.Exp(-ka*hours))
+}
+
+// eventExposure integrates eventLevel from a to b hours after the event was
+// logged, in mg·h. a must not be negative.
+func eventExposure(event CoffeeIntakeEvent, a, b float64, config Config) float64 {
+	return sleepAdjustedExposure(event, a, b, config)
+}
+
+// metabolicExposure integrates the level of event from a to b metabolic
+// hours after it was logged.
+func metabolicExposure(event CoffeeIntakeEvent, a, b float64, config Config) float64 {
+	if config.DecayModel != decayLinearTail || event.Override {
+		return curveExposure(event, a, b, config)
+	}
+	crossover, start, rate := linearTail(event, config)
+	exposure := 0.0
+	if a < crossover {
+		exposure += curveExposure(event, a, math.Min(b, crossover), config)
+	}
+	if b > crossover {
+		// The line start - rate*u is integrated up to where it hits zero
+		end := start / rate
+		area := func(u float64) float64 {
+			u = math.Min(u, end)
+			return start*u - rate*u*u/2
+		}
+		exposure += area(b-crossover) - area(math.Max(a-crossover, 0))
+	}
+	return exposure
+}
+
+// linearTail returns when, in hours after it was logged, event's curve
+// hands over to the linear tail, the level there and the rate in mg/h at
+// which the tail falls.
+func linearTail(event CoffeeIntakeEvent, config Config) (crossover, start, rate float64) {
+	ke := math.Ln2 / config.HalfLifeFor(event.Type)
+	peak := peakHours(event, config)
+	if start = curveLevel(event, peak, config); start <= config.TailCrossoverMg {
+		return peak, start, ke * start
+	}
+	if _, _, _, ok := absorptionModel(event, config); !ok {
+		// Solve amount * e^(-ke t) = crossover level
+		return math.Log(start/config.TailCrossoverMg) / ke, config.TailCrossoverMg, ke * config.TailCrossoverMg
+	}
+	// The curve falls monotonically after its peak; bracket the crossover
+	// and bisect
+	lo, hi := peak, peak+1/ke
+	for curveLevel(event, hi, config) > config.TailCrossoverMg {
+		lo, hi = hi, hi+1/ke
+	}
+	for range 50 {
+		mid := (lo + hi) / 2
+		if curveLevel(event, mid, config) > config.TailCrossoverMg {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, config.TailCrossoverMg, ke * config.TailCrossoverMg
+}
+
+// curveExposure is eventExposure without a linear tail.
+func curveExposure(event CoffeeIntakeEvent, a, b float64, config Config) float64 {
+	dose, ka, ke, ok := absorptionModel(event, config)
+	if !ok {
+		halfLife := config.HalfLifeFor(event.Type)
+		return effectiveDose(event) * halfLife / math.Ln2 * (math.Pow(0.5, a/halfLife) - math.Pow(0.5, b/halfLife))
+	}
+	// Antiderivative of the level curve
+	antiderivative := func(t float64) float64 {
+		if ka == ke {
+			return -dose * (ka*t + 1) * math.Exp(-ke*t)
+		}
+		return dose * ka / (ka - ke) * (math.Exp(-ka*t)/ka - math.Exp(-ke*t)/ke)
+	}
+	return antiderivative(b) - antiderivative(a)
+}
+
+// peakHours returns how many hours after being logged an event's caffeine
+// level peaks: 0 for instant absorption.
+func peakHours(event CoffeeIntakeEvent, config Config) float64 {
+	_, ka, ke, ok := absorptionModel(event, config)
+	if !ok {
+		return 0
+	}
+	if ka == ke {
+		return 1 / ka
+	}
+	return math.Log(ka/ke) / (ka - ke)
+}
+
+// ModelInfo describes the active caffeine model for /api/model. Rate
+// constants are per hour.
+type ModelInfo struct {
+	Name          string             `json:"name"` // "instant" or "two-compartment"
+	Formula       string             `json:"formula"`
+	HalfLifeHours float64            `json:"halfLifeHours"`
+	DecayConstant float64            `json:"decayConstant"` // ke = ln 2 / effectiveHalfLifeHours
+	TypeHalfLives map[string]float64 `json:"typeHalfLives"` // Per drink type; these use ln 2 / (half-life * halfLifeScale) as their ke
+	// HalfLifeScale is the adaptive factor on all half-lives, 1 unless
+	// AdaptiveHalfLife is on (see adaptive.go); EffectiveHalfLifeHours is
+	// HalfLifeHours times it.
+	AdaptiveHalfLife       bool    `json:"adaptiveHalfLife"`
+	HalfLifeScale          float64 `json:"halfLifeScale"`
+	EffectiveHalfLifeHours float64 `json:"effectiveHalfLifeHours"`
+	// AbsorptionMinutes and AbsorptionConstant (ka) are 0 for instant
+	// absorption.
+	AbsorptionMinutes            float64 `json:"absorptionMinutes"`
+	AbsorptionConstant           float64 `json:"absorptionConstant"`
+	EmptyStomachAbsorptionFactor float64 `json:"emptyStomachAbsorptionFactor,omitempty"`
+	EmptyStomachDoseFactor       float64 `json:"emptyStomachDoseFactor,omitempty"`
+	// DecayModel is "exponential" or "linear-tail"; TailCrossoverMg is
+	// only set for the latter.
+	DecayModel      string  `json:"decayModel"`
+	TailCrossoverMg float64 `json:"tailCrossoverMg,omitempty"`
+	TailFormula     string  `json:"tailFormula,omitempty"`
+}
+
+// Model describes the model eventLevel uses with these settings.
+func (c Config) Model() ModelInfo {
+	info := ModelInfo{
+		Name:                   "instant",
+		Formula:                "C(t) = D * 0.5^(t / halfLife) = D * e^(-ke t): the whole dose D is in the blood when the drink is logged and decays exponentially",
+		HalfLifeHours:          c.HalfLifeHours,
+		DecayConstant:          math.Ln2 / (c.HalfLifeHours * c.halfLifeFactor()),
+		TypeHalfLives:          c.TypeHalfLives,
+		AdaptiveHalfLife:       c.AdaptiveHalfLife,
+		HalfLifeScale:          c.halfLifeFactor(),
+		EffectiveHalfLifeHours: c.HalfLifeHours * c.halfLifeFactor(),
+		EmptyStomachDoseFactor: emptyStomachDoseFactor,
+	}
+	if c.AbsorptionMinutes > 0 {
+		info.Name = "two-compartment"
+		info.Formula = "C(t) = D * ka / (ka - ke) * (e^(-ke t) - e^(-ka t)) (Bateman function): caffeine moves from the gut into the blood at rate ka and is eliminated at rate ke"
+		info.AbsorptionMinutes = c.AbsorptionMinutes
+		info.AbsorptionConstant = math.Ln2 / (c.AbsorptionMinutes / 60)
+		info.EmptyStomachAbsorptionFactor = emptyStomachAbsorptionFactor
+	}
+	info.DecayModel = c.DecayModel
+	if c.DecayModel == decayLinearTail {
+		info.TailCrossoverMg = c.TailCrossoverMg
+		info.TailFormula = "once past its peak and down to tailCrossoverMg at t_c, each drink falls linearly: C(t) = max(C(t_c) - ke * C(t_c) * (t - t_c), 0), reaching 0 at t_c + 1/ke"
+	}
+	return info
+}
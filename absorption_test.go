@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func linearTailConfig(absorptionMinutes float64) Config {
+	config := DefaultConfig()
+	config.DecayModel = decayLinearTail
+	config.TailCrossoverMg = 10
+	config.AbsorptionMinutes = absorptionMinutes
+	return config
+}
+
+func TestLinearTailIsContinuousAtTheCrossover(t *testing.T) {
+	const h = 1e-6
+	event := CoffeeIntakeEvent{Time: testStart, Amount: 100}
+	for _, absorption := range []float64{0, 30} {
+		config := linearTailConfig(absorption)
+		crossover, start, rate := linearTail(event, config)
+
+		before, at, after := eventLevel(event, crossover-h, config), eventLevel(event, crossover, config), eventLevel(event, crossover+h, config)
+		if math.Abs(at-10) > 1e-6 || math.Abs(before-after) > 1e-3 {
+			t.Errorf("absorption %g: levels %v, %v, %v around the crossover at %.3f h, want continuous at 10 mg", absorption, before, at, after, crossover)
+		}
+		if absorption == 0 {
+			// With instant absorption the tail is the tangent, so the slope
+			// is continuous too
+			curveSlope := (curveLevel(event, crossover, config) - curveLevel(event, crossover-h, config)) / h
+			if math.Abs(curveSlope+rate) > 1e-3 {
+				t.Errorf("curve slope %v at the crossover, want the tail's %v", curveSlope, -rate)
+			}
+		}
+
+		ke := math.Ln2 / config.HalfLifeHours
+		end := crossover + start/rate
+		if math.Abs(end-(crossover+1/ke)) > 1e-9 {
+			t.Errorf("absorption %g: tail ends %.3f h after the crossover, want 1/ke = %.3f", absorption, end-crossover, 1/ke)
+		}
+		if level := eventLevel(event, end+h, config); level != 0 {
+			t.Errorf("absorption %g: level %v after the tail ends, want 0", absorption, level)
+		}
+		if below, exp := eventLevel(event, crossover+2, config), curveLevel(event, crossover+2, config); below >= exp {
+			t.Errorf("absorption %g: tail level %v not below the exponential %v", absorption, below, exp)
+		}
+	}
+}
+
+func TestLinearTailExposureMatchesLevel(t *testing.T) {
+	event := CoffeeIntakeEvent{Time: testStart, Amount: 100}
+	for _, absorption := range []float64{0, 30} {
+		config := linearTailConfig(absorption)
+		for _, span := range [][2]float64{{0, 48}, {10, 20}, {20, 40}} {
+			const steps = 20000
+			width := (span[1] - span[0]) / steps
+			numeric := 0.0
+			for i := range steps {
+				numeric += eventLevel(event, span[0]+(float64(i)+0.5)*width, config) * width
+			}
+			if got := eventExposure(event, span[0], span[1], config); math.Abs(got-numeric) > 1e-3*math.Max(numeric, 1) {
+				t.Errorf("absorption %g: exposure over %v = %v, want about %v", absorption, span, got, numeric)
+			}
+		}
+	}
+}
+
+func TestExponentialIsTheDefault(t *testing.T) {
+	config := DefaultConfig()
+	event := CoffeeIntakeEvent{Time: testStart, Amount: 100}
+	for _, hours := range []float64{0, 5, 20, 60} {
+		if got, want := eventLevel(event, hours, config), 100*math.Pow(0.5, hours/5); math.Abs(got-want) > 1e-9 {
+			t.Errorf("level after %g h = %v, want %v", hours, got, want)
+		}
+	}
+}
+
+func TestEmptyStomachHitsHarderWithInstantAbsorption(t *testing.T) {
+	config := DefaultConfig()
+	fed := CoffeeIntakeEvent{Time: testStart, Amount: 100}
+	empty := CoffeeIntakeEvent{Time: testStart, Amount: 100, EmptyStomach: true}
+	for _, hours := range []float64{0, 5} {
+		if got, want := eventLevel(empty, hours, config), emptyStomachDoseFactor*eventLevel(fed, hours, config); math.Abs(got-want) > 1e-9 {
+			t.Errorf("empty-stomach level after %g h = %v, want %v", hours, got, want)
+		}
+	}
+	if got, want := eventExposure(empty, 0, 10, config), emptyStomachDoseFactor*eventExposure(fed, 0, 10, config); math.Abs(got-want) > 1e-9 {
+		t.Errorf("empty-stomach exposure = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import "fmt"
+
+// Regular heavy users clear caffeine faster. With Config.AdaptiveHalfLife
+// on, every half-life is scaled by a factor that depends on the average
+// daily intake over the last adaptiveWindowDays days:
+//
+//	scale = 1 - adaptiveMaxReduction * clamp((avg - adaptiveLightMg) / (adaptiveHeavyMg - adaptiveLightMg), 0, 1)
+//
+// Up to adaptiveLightMg a day the configured half-lives are used as they
+// are; from adaptiveHeavyMg on they are shortened by the full
+// adaptiveMaxReduction, and linearly in between. Half-lives are never
+// lengthened. The scale is recomputed when the settings change and
+// hourly with the rollups.
+const (
+	adaptiveWindowDays   = 14
+	adaptiveLightMg      = 200
+	adaptiveHeavyMg      = 600
+	adaptiveMaxReduction = 0.25
+)
+
+// adaptiveScale returns the half-life scale for an average daily intake.
+func adaptiveScale(avgDailyMg float64) float64 {
+	heaviness := (avgDailyMg - adaptiveLightMg) / (adaptiveHeavyMg - adaptiveLightMg)
+	return 1 - adaptiveMaxReduction*min(max(heaviness, 0), 1)
+}
+
+// updateHalfLifeScaleLocked recomputes the adaptive half-life scale from
+// the drinks of the last adaptiveWindowDays days, not counting overrides,
+// and reports whether it changed. Days before the first drink count as
+// days without caffeine. The caller must hold t.mu.
+func (t *Tracker) updateHalfLifeScaleLocked() (bool, error) {
+	scale := 1.0
+	if t.config.AdaptiveHalfLife {
+		events, err := t.store.EventsSince(t.clock.Now().AddDate(0, 0, -adaptiveWindowDays))
+		if err != nil {
+			return false, err
+		}
+		total := 0.0
+		for _, event := range events {
+			if !event.Override && !event.Time.After(t.clock.Now()) {
+				total += event.Amount
+			}
+		}
+		scale = adaptiveScale(total / adaptiveWindowDays)
+	}
+	changed := scale != t.config.halfLifeScale
+	t.config.halfLifeScale = scale
+	return changed, nil
+}
+
+// updateHalfLifeScale recomputes the adaptive half-life scale, notifying
+// clients if the levels changed.
+func (t *Tracker) updateHalfLifeScale() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed, err := t.updateHalfLifeScaleLocked()
+	if err != nil {
+		fmt.Printf("Error adapting the half-life: %v\n", err)
+		return
+	}
+	if changed && t.config.AdaptiveHalfLife {
+		fmt.Printf("Adapted half-lives to %.0f%% of the configured ones\n", t.config.halfLifeScale*100)
+	}
+	if changed {
+		t.version++
+		t.notifier.Notify()
+	}
+}
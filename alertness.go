@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Parameters of the alertness model. The score is
+//
+//	score = baseline + caffeineBoost - sleepDebtPenalty - wakePenalty
+//
+// clamped to [0, 100], where
+//
+//	caffeineBoost    = maxCaffeineBoost * (1 - e^(-level / caffeineBoostScale))
+//	sleepDebtPenalty = debtPenaltyPerHour * max(0, sleepNeedHours - hoursSlept)
+//	wakePenalty      = wakePenaltyPerHour * max(0, hoursAwake - alertHours)
+//
+// The caffeine boost saturates, so doubling a large dose adds little. Hours
+// awake are counted from when last night's sleep was logged.
+const (
+	alertnessBaseline  = 70.0  // Score of a well-rested person without caffeine
+	maxCaffeineBoost   = 30.0  // Most points caffeine can add
+	caffeineBoostScale = 100.0 // mg at which ~63% of the boost is reached
+	sleepNeedHours     = 8.0   // Sleep needed to carry no debt
+	debtPenaltyPerHour = 8.0   // Points lost per hour of sleep debt
+	alertHours         = 8.0   // Hours awake before sleep pressure builds
+	wakePenaltyPerHour = 3.0   // Points lost per hour awake beyond alertHours
+	maxSleepEntryAge   = 36 * time.Hour
+)
+
+// SleepEntry records how long the user slept the night before Time.
+type SleepEntry struct {
+	Time  time.Time `json:"time"`
+	Hours float64   `json:"hours"`
+}
+
+// SleepRequest represents the incoming request to log a night of sleep
+type SleepRequest struct {
+	Hours float64 `json:"hours"`
+}
+
+// Alertness is an estimated 0-100 alertness score and its inputs.
+type Alertness struct {
+	Score          float64  `json:"score"`
+	CaffeineMg     float64  `json:"caffeineMg"`
+	SleepDebtHours float64  `json:"sleepDebtHours"`
+	HoursAwake     *float64 `json:"hoursAwake,omitempty"`
+}
+
+// AddSleep logs last night's sleep duration at the current time.
+func (t *Tracker) AddSleep(hours float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.store.AddSleep(SleepEntry{Time: t.clock.Now(), Hours: hours})
+}
+
+// Alertness estimates the user's alertness at the given time. Without a
+// recent sleep entry the user is assumed to be well rested.
+func (t *Tracker) Alertness(at time.Time) (Alertness, error) {
+	t.mu.Lock()
+	entries, err := t.store.SleepEntries()
+	t.mu.Unlock()
+	if err != nil {
+		return Alertness{}, err
+	}
+
+	level := t.CalculateCaffeineLevelAt(at)
+	result := Alertness{CaffeineMg: level}
+	score := alertnessBaseline + maxCaffeineBoost*(1-math.Exp(-level/caffeineBoostScale))
+
+	if last, ok := latestSleep(entries, at); ok {
+		result.SleepDebtHours = math.Max(0, sleepNeedHours-last.Hours)
+		awake := at.Sub(last.Time).Hours()
+		result.HoursAwake = &awake
+		score -= debtPenaltyPerHour * result.SleepDebtHours
+		score -= wakePenaltyPerHour * math.Max(0, awake-alertHours)
+	}
+
+	result.Score = math.Max(0, math.Min(100, score))
+	return result, nil
+}
+
+// latestSleep returns the most recent sleep entry logged before at, if it is
+// recent enough to describe last night.
+func latestSleep(entries []SleepEntry, at time.Time) (SleepEntry, bool) {
+	var latest SleepEntry
+	found := false
+	for _, entry := range entries {
+		if entry.Time.After(at) || at.Sub(entry.Time) > maxSleepEntryAge {
+			continue
+		}
+		if !found || entry.Time.After(latest.Time) {
+			latest, found = entry, true
+		}
+	}
+	return latest, found
+}
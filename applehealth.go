@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAppleHealthBytes is the largest Apple Health export accepted. The
+	// export holds every health record of the phone, so it is far larger
+	// than the caffeine records in it; it is parsed as a stream.
+	maxAppleHealthBytes = 1 << 30
+
+	appleHealthCaffeineType = "HKQuantityTypeIdentifierDietaryCaffeine"
+	appleHealthTimeLayout   = "2006-01-02 15:04:05 -0700"
+)
+
+// appleHealthRecord is the part of a <Record> element of an Apple Health
+// export.xml that describes caffeine intake.
+type appleHealthRecord struct {
+	Type      string `xml:"type,attr"`
+	Unit      string `xml:"unit,attr"`
+	Value     string `xml:"value,attr"`
+	StartDate string `xml:"startDate,attr"`
+}
+
+// ReadAppleHealth reads the caffeine records of an Apple Health export.xml,
+// one element at a time so the whole export is never held in memory.
+// Records of other types are ignored; caffeine records that can't be used
+// are reported as skipped, indexed by their position among the caffeine
+// records.
+func ReadAppleHealth(r io.Reader) ([]CoffeeIntakeEvent, []SkippedRecord, error) {
+	decoder := xml.NewDecoder(r)
+
+	events := make([]CoffeeIntakeEvent, 0)
+	skipped := make([]SkippedRecord, 0)
+	seenHealthData := false
+	for i := 0; ; {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "HealthData" {
+			seenHealthData = true
+		}
+		if start.Name.Local != "Record" {
+			continue
+		}
+		var record appleHealthRecord
+		if err := decoder.DecodeElement(&record, &start); err != nil {
+			return nil, nil, err
+		}
+		if record.Type != appleHealthCaffeineType {
+			continue
+		}
+		event, err := mapAppleHealthRecord(record)
+		if err == nil {
+			err = validateImported(event)
+		}
+		if err != nil {
+			skipped = append(skipped, SkippedRecord{Index: i, Reason: err.Error()})
+		} else {
+			events = append(events, event)
+		}
+		i++
+	}
+	if !seenHealthData {
+		return nil, nil, errors.New("no HealthData element")
+	}
+	return events, skipped, nil
+}
+
+// mapAppleHealthRecord converts a caffeine record into an event at its
+// start date. Amounts in grams are converted to milligrams.
+func mapAppleHealthRecord(record appleHealthRecord) (CoffeeIntakeEvent, error) {
+	if record.StartDate == "" {
+		return CoffeeIntakeEvent{}, errors.New("missing timestamp")
+	}
+	at, err := time.Parse(appleHealthTimeLayout, record.StartDate)
+	if err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("invalid start date %q", record.StartDate)
+	}
+	amount, err := strconv.ParseFloat(strings.TrimSpace(record.Value), 64)
+	if err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("invalid value %q", record.Value)
+	}
+	switch record.Unit {
+	case "mg":
+	case "g":
+		amount *= 1000
+	default:
+		return CoffeeIntakeEvent{}, fmt.Errorf("unsupported unit %q", record.Unit)
+	}
+	return CoffeeIntakeEvent{Time: at, Amount: amount}, nil
+}
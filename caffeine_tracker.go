@@ -1,28 +1,74 @@
 package main
 
 import (
-	"encoding/json"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"math"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata" // The distroless image ships no zoneinfo
+	"unicode/utf8"
 )
 
 // --- Configuration Constants ---
 const (
-	serverPort = ":8080" // Port for the HTTP server
+	serverPort      = ":8080"  // Port for the HTTP server
+	maxLevelPoints  = 1000     // Maximum number of timestamps accepted by /api/levels
+	maxRequestBytes = 64 << 10 // Default size limit of JSON request bodies
+	maxNoteLength   = 500      // Most characters in a drink's note
+
+	forecastStep       = 30 * time.Minute // Interval between forecast points
+	forecastPoints     = 48               // Points in a forecast, covering 24 hours
+	maxBreakdownEvents = 20               // Drinks listed individually in a forecast breakdown
+
+	crashHorizon          = 6 * time.Hour    // How far ahead crash detection looks
+	crashStep             = 15 * time.Minute // Sampling interval for crash detection
+	defaultCrashThreshold = 20.0             // Drop rate (mg/h) that counts as a crash
+
+	accessLogBackups = 5 // Rotated access log files to keep
+
+	streamKeepalive = 30 * time.Second // Interval of keepalive comments on /api/stream
+	pollTimeout     = 30 * time.Second // How long /api/poll waits for a change
 )
 
 // CoffeeIntakeEvent stores the time and amount of a single coffee intake.
 type CoffeeIntakeEvent struct {
+	ID     string    `json:"id"`
 	Time   time.Time `json:"time"`
 	Amount float64   `json:"amount"`
+	Type   string    `json:"type,omitempty"` // Drink type, e.g. "coffee" or "tea"
+	Name   string    `json:"name,omitempty"` // What was ordered, e.g. "Flat white"
+	Tags   []string  `json:"tags,omitempty"` // Free-form categories, e.g. "work"
+	// Note is free text on why the drink was had, e.g. "deadline"; at most
+	// maxNoteLength characters.
+	Note string `json:"note,omitempty"`
+	// EmptyStomach marks a drink taken without food, which is absorbed
+	// faster and hits harder (see absorption.go).
+	EmptyStomach bool `json:"emptyStomach,omitempty"`
+	// Override marks a calibration event stored by Tracker.Override. Its
+	// amount may be negative, and it takes effect at once.
+	Override bool `json:"override,omitempty"`
+	// ModifiedAt is when the event was logged or last edited; zero for
+	// events stored before it was tracked.
+	ModifiedAt time.Time `json:"modifiedAt"`
 }
 
 // DrinkRequest represents the incoming request to add a drink
 type DrinkRequest struct {
-	Amount float64 `json:"amount"`
+	Amount       float64  `json:"amount"`
+	Type         string   `json:"type,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	EmptyStomach bool     `json:"emptyStomach,omitempty"`
 }
 
 // ForecastPoint represents a point in time with predicted caffeine level
@@ -31,47 +77,440 @@ type ForecastPoint struct {
 	Caffeine    float64   `json:"caffeine"`
 	HasDrink    bool      `json:"hasDrink"`
 	DrinkAmount float64   `json:"drinkAmount,omitempty"`
+	Clamped     bool      `json:"clamped,omitempty"` // Caffeine was capped at maxPlausibleMg
+	// IsFirstOfDay marks a drink that is the first of its local calendar
+	// day, e.g. the morning coffee.
+	IsFirstOfDay bool `json:"isFirstOfDay,omitempty"`
+	// Marker is "wake" or "bedtime" on a point added at the configured
+	// wake time or bedtime rather than on the forecast grid.
+	Marker string `json:"marker,omitempty"`
+}
+
+// LevelPoint is the caffeine level at a single requested time
+type LevelPoint struct {
+	Time     time.Time `json:"time"`
+	Caffeine float64   `json:"caffeine"`
 }
 
 // Tracker holds the state of coffee intake events.
 // It's made thread-safe with a mutex for potential concurrent access in a real server.
 type Tracker struct {
-	mu     sync.Mutex
-	events []CoffeeIntakeEvent
+	mu       sync.Mutex
+	store    Store
+	clock    Clock
+	ids      *idGenerator
+	config   Config
+	version  uint64    // Incremented on every mutation of events or config
+	notifier *Notifier // Signalled on every mutation of events or config
+
+	futureEvents atomic.Int64 // Future-dated events found by the last self-check
 }
 
-// NewTracker creates and returns a new Tracker instance.
+// NewTracker creates and returns a new Tracker instance backed by memory.
 func NewTracker() *Tracker {
+	return NewTrackerWithStore(newMemoryStore(), systemClock{})
+}
+
+// NewTrackerWithStore creates a Tracker that keeps its events in the given
+// store and reads the current time from clock.
+func NewTrackerWithStore(store Store, clock Clock) *Tracker {
 	return &Tracker{
-		events: make([]CoffeeIntakeEvent, 0),
+		store:    store,
+		clock:    clock,
+		ids:      newIDGenerator(),
+		config:   DefaultConfig(),
+		notifier: NewNotifier(),
+		// Start from the clock so versions aren't reused after a restart
+		version: uint64(clock.Now().UnixNano()),
 	}
 }
 
+// Now returns the current time according to the tracker's clock.
+func (t *Tracker) Now() time.Time {
+	return t.clock.Now()
+}
+
 // AddDrink logs a new drink intake event with the current time and specified amount.
-func (t *Tracker) AddDrink(amount float64) {
+func (t *Tracker) AddDrink(amount float64) error {
+	_, _, err := t.AddEvent(CoffeeIntakeEvent{Amount: amount})
+	return err
+}
+
+// errPastLastCall is returned when a drink after the last call is rejected
+// because LastCallStrict is set.
+var errPastLastCall = errors.New("drink is after the last call")
+
+// AddEvent logs a drink intake event, stamping it with the current time if
+// it has none and assigning it a new ID, and returns the stored event. If
+// the drink follows the previous drink sooner than MinIntervalMinutes,
+// comes after the last call, takes the intake window over its limit, or
+// takes its day to WarnAtFraction of the daily limit, it is stored anyway
+// and warning says so;
+// with LastCallStrict a drink after the last call fails with
+// errPastLastCall instead.
+func (t *Tracker) AddEvent(event CoffeeIntakeEvent) (stored CoffeeIntakeEvent, warning string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = t.clock.Now()
+	}
+	var warnings []string
+	if t.pastLastCallLocked(event.Time) {
+		if t.config.LastCallStrict {
+			return CoffeeIntakeEvent{}, "", errPastLastCall
+		}
+		warnings = append(warnings, fmt.Sprintf("logged after the last call at %02d:00; it may disturb your sleep", t.config.LastCallHour))
+	}
+	if w := t.intervalWarningLocked(event.Time); w != "" {
+		warnings = append(warnings, w)
+	}
+	if w := t.intakeWarningLocked(event); w != "" {
+		warnings = append(warnings, w)
+	}
+	if w := t.limitWarningLocked(event); w != "" {
+		warnings = append(warnings, w)
+	}
+	warning = strings.Join(warnings, "; ")
+	event.Tags = normalizeTags(event.Tags)
+	event.Note = strings.TrimSpace(event.Note)
+	event.ID = t.ids.Next(event.Time)
+	event.ModifiedAt = t.clock.Now()
+	if err := t.store.Add(event); err != nil {
+		return CoffeeIntakeEvent{}, "", fmt.Errorf("storing drink: %w", err)
+	}
+	t.invalidateRollupLocked(event.Time)
+	t.version++
+	t.notifier.Notify()
+	fmt.Printf("Logged drink at %s (%.1f mg)\n", event.Time.Format("15:04:05"), event.Amount)
+	t.evictLocked()
+	return event, warning, nil
+}
+
+// pastLastCallLocked reports whether a drink at at comes at or after the
+// last call of its stats day, i.e. between LastCallHour and the next
+// ResetHour. The caller must hold t.mu.
+func (t *Tracker) pastLastCallLocked(at time.Time) bool {
+	if t.config.LastCallHour == 0 {
+		return false
+	}
+	loc := t.config.Location()
+	dayStart := resetBoundary(at, t.config.ResetHour, loc)
+	local := dayStart.In(loc)
+	lastCall := time.Date(local.Year(), local.Month(), local.Day(), t.config.LastCallHour, 0, 0, 0, loc)
+	if lastCall.Before(dayStart) {
+		lastCall = lastCall.AddDate(0, 0, 1)
+	}
+	return !at.Before(lastCall)
+}
+
+// intervalWarningLocked returns a warning if a drink at at would follow the
+// latest drink at or before it sooner than MinIntervalMinutes, or "" if
+// not. A store error is logged and skips the check. The caller must hold
+// t.mu.
+func (t *Tracker) intervalWarningLocked(at time.Time) string {
+	if t.config.MinIntervalMinutes <= 0 {
+		return ""
+	}
+	interval := time.Duration(t.config.MinIntervalMinutes) * time.Minute
+	recent, err := t.store.EventsSince(at.Add(-interval))
+	if err != nil {
+		fmt.Printf("Error checking drink interval: %v\n", err)
+		return ""
+	}
+	var previous *CoffeeIntakeEvent
+	for i := range recent {
+		if !recent[i].Time.After(at) && !recent[i].Override {
+			previous = &recent[i]
+		}
+	}
+	if previous == nil {
+		return ""
+	}
+	return fmt.Sprintf("logged %d minutes after the previous drink; the minimum interval is %d minutes",
+		int(at.Sub(previous.Time).Minutes()), t.config.MinIntervalMinutes)
+}
+
+// intakeWarningLocked returns a warning if event takes the total logged in
+// the intake window ending at its time over IntakeWindowLimitMg, or "" if
+// not. A store error is logged and skips the check. The caller must hold
+// t.mu.
+func (t *Tracker) intakeWarningLocked(event CoffeeIntakeEvent) string {
+	if t.config.IntakeWindowLimitMg <= 0 {
+		return ""
+	}
+	window := time.Duration(t.config.IntakeWindowMinutes) * time.Minute
+	recent, err := t.store.EventsSince(event.Time.Add(-window))
+	if err != nil {
+		fmt.Printf("Error checking intake window: %v\n", err)
+		return ""
+	}
+	total := event.Amount + windowTotal(recent, event.Time, window)
+	if total <= t.config.IntakeWindowLimitMg {
+		return ""
+	}
+	return fmt.Sprintf("%g mg taken within %d minutes; the advised limit is %g mg",
+		t.config.Round(total), t.config.IntakeWindowMinutes, t.config.IntakeWindowLimitMg)
+}
+
+// limitWarningLocked returns a warning if event takes the counted total of
+// its stats day from below WarnAtFraction of DailyLimitMg to at or above
+// it, or "" if not; later drinks that day don't repeat the warning. A
+// store error is logged and skips the check. The caller must hold t.mu.
+func (t *Tracker) limitWarningLocked(event CoffeeIntakeEvent) string {
+	if t.config.WarnAtFraction <= 0 {
+		return ""
+	}
+	dayStart := resetBoundary(event.Time, t.config.ResetHour, t.config.Location())
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	day, err := t.store.EventsSince(dayStart)
+	if err != nil {
+		fmt.Printf("Error checking daily limit: %v\n", err)
+		return ""
+	}
+	consumed := 0.0
+	for _, e := range day {
+		if e.Time.Before(dayEnd) && !e.Override {
+			consumed += e.Amount
+		}
+	}
+	mark := t.config.WarnAtFraction * t.config.DailyLimitMg
+	before := math.Max(consumed-t.config.GraceMg, 0)
+	after := math.Max(consumed+event.Amount-t.config.GraceMg, 0)
+	if before >= mark || after < mark {
+		return ""
+	}
+	return limitWarning(after, t.config)
+}
+
+// windowTotal sums the amounts of the drinks in (end-window, end].
+func windowTotal(events []CoffeeIntakeEvent, end time.Time, window time.Duration) float64 {
+	total := 0.0
+	for _, event := range events {
+		if event.Time.After(end.Add(-window)) && !event.Time.After(end) && !event.Override {
+			total += event.Amount
+		}
+	}
+	return total
+}
+
+// IntakeInWindow returns the total amount logged in the window ending now.
+func (t *Tracker) IntakeInWindow(window time.Duration) float64 {
+	now := t.clock.Now()
+	return windowTotal(t.eventsSince(now.Add(-window)), now, window)
+}
+
+// evictLocked enforces the MaxEvents cap by deleting the oldest events.
+// A failure only delays eviction to the next add, so it is logged rather
+// than failing the add. The caller must hold t.mu.
+func (t *Tracker) evictLocked() {
+	if t.config.MaxEvents <= 0 {
+		return
+	}
+	evicted, err := t.store.TrimOldest(t.config.MaxEvents)
+	if err != nil {
+		fmt.Printf("Error evicting old drinks: %v\n", err)
+		return
+	}
+	for _, event := range evicted {
+		t.invalidateRollupLocked(event.Time)
+	}
+	if len(evicted) > 0 {
+		fmt.Printf("Evicted %d oldest drinks (max %d)\n", len(evicted), t.config.MaxEvents)
+	}
+}
+
+// ImportEvents appends already-timestamped events, assigning each a new ID.
+// It stops at the first store error and returns how many were stored.
+func (t *Tracker) ImportEvents(events []CoffeeIntakeEvent) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	imported := 0
+	now := t.clock.Now()
+	for _, event := range events {
+		event.Tags = normalizeTags(event.Tags)
+		event.Note = strings.TrimSpace(event.Note)
+		event.ID = t.ids.Next(event.Time)
+		event.ModifiedAt = now
+		if err := t.store.Add(event); err != nil {
+			return imported, fmt.Errorf("storing drink: %w", err)
+		}
+		t.invalidateRollupLocked(event.Time)
+		imported++
+	}
+	if imported > 0 {
+		t.version++
+		t.notifier.Notify()
+		fmt.Printf("Imported %d drinks\n", imported)
+	}
+	t.evictLocked()
+	return imported, nil
+}
+
+// errEventNotFound is returned when no event has the requested ID.
+var errEventNotFound = errors.New("event not found")
+
+// Event returns the event with the given ID.
+func (t *Tracker) Event(id string) (CoffeeIntakeEvent, bool) {
+	for _, event := range t.snapshot() {
+		if event.ID == id {
+			return event, true
+		}
+	}
+	return CoffeeIntakeEvent{}, false
+}
+
+// UpdateEvent replaces the stored event that has the same ID, stamping its
+// modification time, and returns the stored event.
+func (t *Tracker) UpdateEvent(event CoffeeIntakeEvent) (CoffeeIntakeEvent, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, removed, err := t.store.Remove(event.ID)
+	if err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("removing drink: %w", err)
+	}
+	if !removed {
+		return CoffeeIntakeEvent{}, errEventNotFound
+	}
+	t.invalidateRollupLocked(old.Time)
+	event.Tags = normalizeTags(event.Tags)
+	event.Note = strings.TrimSpace(event.Note)
+	event.ModifiedAt = t.clock.Now()
+	if err := t.store.Add(event); err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("storing drink: %w", err)
+	}
+	t.invalidateRollupLocked(event.Time)
+	t.version++
+	t.notifier.Notify()
+	fmt.Printf("Updated drink %s\n", event.ID)
+	return event, nil
+}
+
+// DeleteEvent removes the event with the given ID and leaves a tombstone so
+// syncing clients learn about the deletion. Within the restore window the
+// event can be brought back with RestoreEvent.
+func (t *Tracker) DeleteEvent(id string) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	event := CoffeeIntakeEvent{
-		Time:   time.Now(),
-		Amount: amount,
+	old, removed, err := t.store.Remove(id)
+	if err != nil {
+		return fmt.Errorf("removing drink: %w", err)
+	}
+	if !removed {
+		return errEventNotFound
+	}
+	t.invalidateRollupLocked(old.Time)
+	t.version++
+	t.notifier.Notify()
+	fmt.Printf("Deleted drink %s\n", id)
+
+	// The drink is gone either way; a lost tombstone only costs syncing
+	// clients a full refetch
+	now := t.clock.Now()
+	if err := t.store.AddTombstone(Tombstone{ID: id, DeletedAt: now}); err != nil {
+		fmt.Printf("Error recording deletion of %s: %v\n", id, err)
+	}
+	if err := t.store.TrimTombstones(now.Add(-tombstoneRetention), maxTombstones); err != nil {
+		fmt.Printf("Error trimming tombstones: %v\n", err)
+	}
+	if t.config.RestoreWindowHours > 0 {
+		if err := t.store.AddTrash(DeletedEvent{CoffeeIntakeEvent: old, DeletedAt: now}); err != nil {
+			fmt.Printf("Error keeping deleted drink %s for restoring: %v\n", id, err)
+		}
 	}
-	t.events = append(t.events, event)
-	fmt.Printf("Logged drink at %s. Current count: %d\n", event.Time.Format("15:04:05"), len(t.events))
+	return nil
 }
 
-// CalculateCaffeineLevelAt calculates the caffeine level at a specific time
+// CalculateCaffeineLevelAt calculates the caffeine level at a specific time,
+// capped at the configured plausible maximum.
 func (t *Tracker) CalculateCaffeineLevelAt(targetTime time.Time) float64 {
+	level, _ := t.PlausibleLevelAt(targetTime)
+	return level
+}
+
+// CalculateCaffeineLevelWith calculates the uncapped caffeine level at a
+// specific time as if every drink had the given half-life in hours,
+// ignoring HalfLifeHours, TypeHalfLives and the adaptive scale. The
+// settings are not changed.
+func (t *Tracker) CalculateCaffeineLevelWith(targetTime time.Time, halfLife float64) float64 {
+	config := t.Config()
+	config.HalfLifeHours, config.TypeHalfLives, config.AdaptiveHalfLife = halfLife, nil, false
+	return caffeineLevelAt(t.snapshot(), targetTime, config)
+}
+
+// EffectiveLevelAt calculates the caffeine level at a specific time as it
+// affects sleep: the level scaled by the sensitivity for that hour of the
+// day. CalculateCaffeineLevelAt stays the objective level.
+func (t *Tracker) EffectiveLevelAt(targetTime time.Time) float64 {
+	config := t.Config()
+	return effectiveLevelAt(t.snapshot(), targetTime, config)
+}
+
+// PlausibleLevelAt calculates the caffeine level at a specific time and caps
+// it at MaxPlausibleMg, reporting whether it was capped. Projections such as
+// the safe-to-sleep time use the uncapped level.
+func (t *Tracker) PlausibleLevelAt(targetTime time.Time) (level float64, clamped bool) {
+	config := t.Config()
+	return config.Clamp(caffeineLevelAt(t.snapshot(), targetTime, config))
+}
+
+// LevelsAt calculates the caffeine level at each of the given times against a
+// single snapshot of the events, so all points are mutually consistent.
+func (t *Tracker) LevelsAt(times []time.Time) []LevelPoint {
+	events, config := t.snapshot(), t.Config()
+	levels := make([]LevelPoint, 0, len(times))
+	for _, at := range times {
+		levels = append(levels, LevelPoint{
+			Time:     at,
+			Caffeine: caffeineLevelAt(events, at, config),
+		})
+	}
+	return levels
+}
+
+// Version returns a counter that changes whenever events or settings are
+// modified through this tracker. Writes made by other replicas sharing the
+// same store are not counted.
+func (t *Tracker) Version() uint64 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return t.version
+}
 
-	totalCaffeine := 0.0
+// EventsState returns how many events are stored and when the most recently
+// logged or edited one changed. Unlike Version, both are read from the
+// store, so they also move when another server sharing it makes a change.
+func (t *Tracker) EventsState() (count int, modified time.Time) {
+	for _, event := range t.snapshot() {
+		if event.ModifiedAt.After(modified) {
+			modified = event.ModifiedAt
+		}
+		count++
+	}
+	return count, modified
+}
 
-	if len(t.events) == 0 {
-		return 0.0
+// snapshot returns a copy of the events that is safe to use without the lock.
+// If the store can't be read the error is logged and no events are returned.
+func (t *Tracker) snapshot() []CoffeeIntakeEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events, err := t.store.Events()
+	if err != nil {
+		fmt.Printf("Error reading events: %v\n", err)
+		return []CoffeeIntakeEvent{}
 	}
+	return events
+}
 
-	for _, event := range t.events {
+// caffeineLevelAt sums the remaining caffeine of all events at the target time.
+func caffeineLevelAt(events []CoffeeIntakeEvent, targetTime time.Time, config Config) float64 {
+	totalCaffeine := 0.0
+
+	for _, event := range events {
 		timeElapsed := targetTime.Sub(event.Time)
 		timeElapsedHours := timeElapsed.Hours()
 
@@ -79,108 +518,454 @@ func (t *Tracker) CalculateCaffeineLevelAt(targetTime time.Time) float64 {
 			continue
 		}
 
-		// Caffeine decay formula: C = C0 * (0.5)^(t / T_half)
-		remainingCaffeine := event.Amount * math.Pow(0.5, timeElapsedHours/5.0) // 5 hours half-life
-		totalCaffeine += remainingCaffeine
+		totalCaffeine += eventLevel(event, timeElapsedHours, config)
+	}
+
+	// A negative override can outweigh drinks still being absorbed
+	return max(totalCaffeine, 0)
+}
+
+// LevelContribution is one event's share of the caffeine level at a time.
+type LevelContribution struct {
+	EventID       string    `json:"eventId"`
+	Time          time.Time `json:"time"`
+	Amount        float64   `json:"amount"`
+	HalfLifeHours float64   `json:"halfLifeHours"`
+	ElapsedHours  float64   `json:"elapsedHours"` // Negative for drinks logged for later
+	RemainingMg   float64   `json:"remainingMg"`  // 0 for drinks logged for later
+}
+
+// effectiveLevelAt is caffeineLevelAt scaled by the hourly sensitivity.
+func effectiveLevelAt(events []CoffeeIntakeEvent, targetTime time.Time, config Config) float64 {
+	return caffeineLevelAt(events, targetTime, config) * config.SensitivityAt(targetTime)
+}
+
+// LevelBreakdown is the caffeine level at a time with the contribution of
+// every event, as computed by caffeineLevelAt.
+type LevelBreakdown struct {
+	At            time.Time           `json:"at"`
+	TotalMg       float64             `json:"totalMg"`
+	Contributions []LevelContribution `json:"contributions"`
+}
+
+// LevelBreakdownAt explains the caffeine level at the given time event by
+// event. Values are unrounded and in mg.
+func (t *Tracker) LevelBreakdownAt(at time.Time) LevelBreakdown {
+	events, config := t.snapshot(), t.Config()
+	breakdown := LevelBreakdown{At: at, Contributions: make([]LevelContribution, 0, len(events))}
+	for _, event := range events {
+		contribution := levelContribution(event, at, config)
+		breakdown.TotalMg += contribution.RemainingMg
+		breakdown.Contributions = append(breakdown.Contributions, contribution)
 	}
+	return breakdown
+}
+
+// ActiveContributions returns the events still contributing more than minMg
+// to the caffeine level at now, largest contribution first. Values are
+// unrounded and in mg.
+func (t *Tracker) ActiveContributions(now time.Time, minMg float64) []LevelContribution {
+	events, config := t.snapshot(), t.Config()
+	active := make([]LevelContribution, 0)
+	for _, event := range events {
+		if contribution := levelContribution(event, now, config); contribution.RemainingMg > minMg {
+			active = append(active, contribution)
+		}
+	}
+	slices.SortStableFunc(active, func(a, b LevelContribution) int {
+		return cmp.Compare(b.RemainingMg, a.RemainingMg)
+	})
+	return active
+}
 
-	return totalCaffeine
+// levelContribution computes what event contributes to the level at at.
+func levelContribution(event CoffeeIntakeEvent, at time.Time, config Config) LevelContribution {
+	contribution := LevelContribution{
+		EventID:       event.ID,
+		Time:          event.Time,
+		Amount:        event.Amount,
+		HalfLifeHours: config.HalfLifeFor(event.Type),
+		ElapsedHours:  at.Sub(event.Time).Hours(),
+	}
+	if contribution.ElapsedHours >= 0 {
+		contribution.RemainingMg = eventLevel(event, contribution.ElapsedHours, config)
+	}
+	return contribution
 }
 
 // GenerateForecast generates a forecast of caffeine levels for the next 24 hours
 func (t *Tracker) GenerateForecast() []ForecastPoint {
-	now := time.Now()
+	return forecastFrom(t.snapshot(), t.clock.Now(), t.Config())
+}
+
+// WithScheduleMarkers adds a point marked "wake" or "bedtime" at every
+// configured wake time and bedtime within the span of forecast, keeping the
+// points in time order.
+func (t *Tracker) WithScheduleMarkers(forecast []ForecastPoint) []ForecastPoint {
+	if len(forecast) == 0 {
+		return forecast
+	}
+	events, config := t.snapshot(), t.Config()
+	loc := config.Location()
+	from, to := forecast[0].Time, forecast[len(forecast)-1].Time
+	for _, marker := range []struct{ name, clock string }{{"wake", config.WakeTime}, {"bedtime", config.Bedtime}} {
+		if marker.clock == "" {
+			continue
+		}
+		for day := startOfDay(from, loc); !day.After(to); day = day.AddDate(0, 0, 1) {
+			at, _ := clockTimeOn(marker.clock, day, loc)
+			if at.Before(from) || at.After(to) {
+				continue
+			}
+			forecast = append(forecast, ForecastPoint{
+				Time:     at,
+				Caffeine: caffeineLevelAt(events, at, config),
+				Marker:   marker.name,
+			})
+		}
+	}
+	slices.SortStableFunc(forecast, func(a, b ForecastPoint) int {
+		return a.Time.Compare(b.Time)
+	})
+	return forecast
+}
+
+// ForecastWithout generates the forecast as if the event with the given ID
+// had never been logged. The event itself is left untouched. It returns
+// ok=false if no event has that ID.
+func (t *Tracker) ForecastWithout(id string) (forecast []ForecastPoint, ok bool) {
+	events := t.snapshot()
+	kept := make([]CoffeeIntakeEvent, 0, len(events))
+	for _, event := range events {
+		if event.ID == id {
+			ok = true
+			continue
+		}
+		kept = append(kept, event)
+	}
+	if !ok {
+		return nil, false
+	}
+	return forecastFrom(kept, t.clock.Now(), t.Config()), true
+}
+
+// forecastFrom generates a 24-hour forecast starting at now over a snapshot of events.
+func forecastFrom(events []CoffeeIntakeEvent, now time.Time, config Config) []ForecastPoint {
 	forecast := make([]ForecastPoint, 0)
+	first := firstOfDay(events, config.Location())
 
 	// Generate points for every 30 minutes for the next 24 hours
-	for i := 0; i < 48; i++ {
-		targetTime := now.Add(time.Duration(i*30) * time.Minute)
-		caffeine := t.CalculateCaffeineLevelAt(targetTime)
+	for i := 0; i < forecastPoints; i++ {
+		targetTime := now.Add(time.Duration(i) * forecastStep)
+		caffeine := caffeineLevelAt(events, targetTime, config)
 
-		// Check if there's a drink at this time
-		var hasDrink bool
+		// A point has a drink if one is logged within its step, i.e. at or
+		// after the point and before the next one. Several drinks in one
+		// step are combined into one marker with their total amount.
+		// Overrides are calibrations, not drinks, so they get no marker.
+		var hasDrink, isFirst bool
 		var drinkAmount float64
-		for _, event := range t.events {
-			if event.Time.Format("15:04") == targetTime.Format("15:04") {
-				hasDrink = true
-				drinkAmount = event.Amount
+		bucketEnd := targetTime.Add(forecastStep)
+		for j, event := range events {
+			if !event.Time.Before(bucketEnd) {
 				break
 			}
+			if event.Time.Before(targetTime) || event.Override {
+				continue
+			}
+			hasDrink = true
+			drinkAmount += event.Amount
+			isFirst = isFirst || first[j]
 		}
 
 		forecast = append(forecast, ForecastPoint{
-			Time:        targetTime,
-			Caffeine:    caffeine,
-			HasDrink:    hasDrink,
-			DrinkAmount: drinkAmount,
+			Time:         targetTime,
+			Caffeine:     caffeine,
+			HasDrink:     hasDrink,
+			DrinkAmount:  drinkAmount,
+			IsFirstOfDay: isFirst,
 		})
 	}
 
 	return forecast
 }
 
-// GetEvents returns all coffee intake events
-func (t *Tracker) GetEvents() []CoffeeIntakeEvent {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	return t.events
+// firstOfDay reports, for each of the chronological events, whether it is
+// the first drink on its calendar day in loc. Overrides are never first.
+func firstOfDay(events []CoffeeIntakeEvent, loc *time.Location) []bool {
+	first := make([]bool, len(events))
+	var day time.Time
+	for i, event := range events {
+		if event.Override {
+			continue
+		}
+		if d := startOfDay(event.Time, loc); day.IsZero() || !d.Equal(day) {
+			first[i], day = true, d
+		}
+	}
+	return first
 }
 
-func main() {
-	fmt.Println("--- Go Caffeine Tracker Backend Logic ---")
-	tracker := NewTracker()
+// FirstOfDayIDs returns the IDs of the events that are the first drink of
+// their calendar day in the configured timezone.
+func (t *Tracker) FirstOfDayIDs() map[string]bool {
+	events := t.snapshot()
+	ids := make(map[string]bool)
+	for i, first := range firstOfDay(events, t.Config().Location()) {
+		if first {
+			ids[events[i].ID] = true
+		}
+	}
+	return ids
+}
+
+// IsFirstOfDay reports whether event is the first drink of its calendar day
+// in the configured timezone.
+func (t *Tracker) IsFirstOfDay(event CoffeeIntakeEvent) bool {
+	for _, e := range t.eventsSince(startOfDay(event.Time, t.Config().Location())) {
+		if !e.Override {
+			return e.ID == event.ID
+		}
+	}
+	return false
+}
+
+// BreakdownPoint is a forecast point split into the contribution of each
+// drink, keyed by event ID.
+type BreakdownPoint struct {
+	Time          time.Time          `json:"time"`
+	Contributions map[string]float64 `json:"contributions"`
+	Other         float64            `json:"other,omitempty"` // Sum of the drinks left out of Contributions
+}
+
+// ForecastBreakdown splits the forecast into per-drink contributions. Only
+// the maxBreakdownEvents drinks with the largest peak contribution within
+// the forecast are listed individually; the rest are summed into Other.
+func (t *Tracker) ForecastBreakdown() []BreakdownPoint {
+	events, config := t.snapshot(), t.Config()
+	now := t.clock.Now()
+
+	// One pass over the snapshot: each drink's curve over all forecast points
+	type curve struct {
+		id     string
+		levels []float64
+		peak   float64
+	}
+	curves := make([]curve, 0, len(events))
+	for _, event := range events {
+		c := curve{id: event.ID, levels: make([]float64, forecastPoints)}
+		single := []CoffeeIntakeEvent{event}
+		for i := range c.levels {
+			c.levels[i] = caffeineLevelAt(single, now.Add(time.Duration(i)*forecastStep), config)
+			c.peak = max(c.peak, c.levels[i])
+		}
+		if c.peak > 0 {
+			curves = append(curves, c)
+		}
+	}
+	slices.SortStableFunc(curves, func(a, b curve) int {
+		return cmp.Compare(b.peak, a.peak)
+	})
 
-	// Serve static files
-	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/", fs)
+	points := make([]BreakdownPoint, forecastPoints)
+	for i := range points {
+		points[i] = BreakdownPoint{
+			Time:          now.Add(time.Duration(i) * forecastStep),
+			Contributions: make(map[string]float64),
+		}
+	}
+	for rank, c := range curves {
+		for i, level := range c.levels {
+			if rank < maxBreakdownEvents {
+				points[i].Contributions[c.id] = level
+			} else {
+				points[i].Other += level
+			}
+		}
+	}
+	return points
+}
 
-	// API endpoints
-	http.HandleFunc("/api/add-coffee", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// drinkMarkers keeps only the forecast points that have a drink.
+func drinkMarkers(forecast []ForecastPoint) []ForecastPoint {
+	markers := make([]ForecastPoint, 0)
+	for _, point := range forecast {
+		if point.HasDrink {
+			markers = append(markers, point)
 		}
+	}
+	return markers
+}
+
+// ColumnarForecast is a forecast as parallel arrays instead of an array of
+// points, which is smaller on the wire and what plotting libraries take.
+// Drinks holds the amount logged within each point's step, 0 if none.
+type ColumnarForecast struct {
+	Times    []time.Time `json:"times"`
+	Caffeine []float64   `json:"caffeine"`
+	Drinks   []float64   `json:"drinks"`
+}
 
-		var req DrinkRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
+// columnar converts a forecast to parallel arrays.
+func columnar(forecast []ForecastPoint) ColumnarForecast {
+	c := ColumnarForecast{
+		Times:    make([]time.Time, len(forecast)),
+		Caffeine: make([]float64, len(forecast)),
+		Drinks:   make([]float64, len(forecast)),
+	}
+	for i, point := range forecast {
+		c.Times[i], c.Caffeine[i], c.Drinks[i] = point.Time, point.Caffeine, point.DrinkAmount
+	}
+	return c
+}
+
+// SteepestDrop finds the forecast segment with the fastest falling caffeine level
+// between from and from+horizon. It returns the start of that segment and its
+// rate of decline in mg per hour, or ok=false if the level never falls.
+func (t *Tracker) SteepestDrop(from time.Time, horizon time.Duration) (at time.Time, rate float64, ok bool) {
+	events, config := t.snapshot(), t.Config()
+	prev := caffeineLevelAt(events, from, config)
+
+	for step := crashStep; step <= horizon; step += crashStep {
+		next := caffeineLevelAt(events, from.Add(step), config)
+		// Negative derivative of the level over this segment, in mg/h
+		drop := (prev - next) / crashStep.Hours()
+		if drop > rate {
+			at, rate, ok = from.Add(step-crashStep), drop, true
 		}
+		prev = next
+	}
 
-		tracker.AddDrink(req.Amount)
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-	})
+	return at, rate, ok
+}
+
+// GetEvents returns all coffee intake events
+func (t *Tracker) GetEvents() []CoffeeIntakeEvent {
+	return t.snapshot()
+}
+
+// EventCount returns the number of stored events.
+func (t *Tracker) EventCount() int {
+	return len(t.snapshot())
+}
 
-	http.HandleFunc("/api/caffeine-level", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// EventsByTag returns the events carrying the given tag, in chronological order.
+func (t *Tracker) EventsByTag(tag string) []CoffeeIntakeEvent {
+	tagged := make([]CoffeeIntakeEvent, 0)
+	for _, event := range t.snapshot() {
+		if slices.Contains(event.Tags, tag) {
+			tagged = append(tagged, event)
 		}
-		level := tracker.CalculateCaffeineLevelAt(time.Now())
-		json.NewEncoder(w).Encode(map[string]float64{"level": level})
-	})
+	}
+	return tagged
+}
+
+// validateNote rejects notes longer than maxNoteLength characters.
+func validateNote(note string) error {
+	if utf8.RuneCountInString(strings.TrimSpace(note)) > maxNoteLength {
+		return fmt.Errorf("note must be at most %d characters", maxNoteLength)
+	}
+	return nil
+}
 
-	http.HandleFunc("/api/forecast", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// normalizeTags trims tags and drops empty and duplicate ones.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
 		}
-		forecast := tracker.GenerateForecast()
-		json.NewEncoder(w).Encode(forecast)
-	})
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// LatestEvent returns the most recent coffee intake event, if any. Stores
+// keep events in chronological order, so it is the last one.
+func (t *Tracker) LatestEvent() (CoffeeIntakeEvent, bool) {
+	events := t.snapshot()
+	if len(events) == 0 {
+		return CoffeeIntakeEvent{}, false
+	}
+	return events[len(events)-1], true
+}
 
-	http.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+func main() {
+	storeSpec := flag.String("store", "memory", `event store: "memory" or a redis://host:port/db URL`)
+	accessLogPath := flag.String("access-log", "", "write the access log to this file instead of stdout")
+	accessLogMaxMB := flag.Int("access-log-max-mb", 10, "rotate the access log file when it reaches this size in MB")
+	corsOrigins := flag.String("cors-origins", "", `comma-separated origins allowed to call the API from a browser, or "*" for any`)
+	readOnly := flag.Bool("read-only", false, "reject every request that changes data or settings, e.g. for a public demo")
+	disableFeatures := flag.String("disable-features", "", "comma-separated optional features not to serve: "+strings.Join(featureNames(), ", "))
+	debug := flag.Bool("debug", false, "enable /api/debug endpoints that expose model internals")
+	basePath := flag.String("base-path", "", `serve everything below this path prefix, e.g. "/coffee" behind a reverse proxy`)
+	seed := flag.Bool("seed", false, "pre-populate an empty store with a day of demo drinks (for demos only)")
+	clampFuture := flag.Bool("clamp-future-events", false, "move drinks logged for the future to the current time during the hourly self-check")
+	flag.Parse()
+
+	disabledFeatures, err := parseDisabledFeatures(*disableFeatures)
+	if err != nil {
+		fmt.Printf("Error in -disable-features: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("--- Go Caffeine Tracker Backend Logic ---")
+	fmt.Printf("Version %s (commit %s, built %s)\n", version, commit, buildTime)
+	store, err := openStore(*storeSpec)
+	if err != nil {
+		fmt.Printf("Error opening store: %v\n", err)
+		os.Exit(1)
+	}
+	tracker := NewTrackerWithStore(store, systemClock{})
+	if *seed {
+		if err := SeedDemoData(tracker, systemClock{}); err != nil {
+			fmt.Printf("Not seeding demo data: %v\n", err)
 		}
-		events := tracker.GetEvents()
-		json.NewEncoder(w).Encode(events)
-	})
+	}
+	go tracker.RunDailyReset(context.Background())
+	go retryStore(context.Background(), store)
+	go tracker.RunFutureCheck(context.Background(), *clampFuture)
+	go tracker.RunTrashSweep(context.Background())
+	openProfile := func(name string) (*Tracker, error) {
+		store, err := openProfileStore(*storeSpec, name)
+		if err != nil {
+			return nil, err
+		}
+		tracker := NewTrackerWithStore(store, systemClock{})
+		go tracker.RunDailyReset(context.Background())
+		go retryStore(context.Background(), store)
+		go tracker.RunFutureCheck(context.Background(), *clampFuture)
+		go tracker.RunTrashSweep(context.Background())
+		return tracker, nil
+	}
+	opts := serverOptions{
+		BasePath:         normalizeBasePath(*basePath),
+		Debug:            *debug,
+		CORS:             *corsOrigins,
+		ReadOnly:         *readOnly,
+		DisabledFeatures: disabledFeatures,
+	}
+	if *accessLogPath != "" {
+		accessLog, err := openRotatingFile(*accessLogPath, int64(*accessLogMaxMB)<<20, accessLogBackups)
+		if err != nil {
+			fmt.Printf("Error opening access log: %v\n", err)
+			os.Exit(1)
+		}
+		defer accessLog.Close()
+		opts.AccessLog = accessLog
+	}
+	profiles := newProfiles(tracker, openProfile)
+	if err := profiles.load(); err != nil {
+		fmt.Printf("Error opening profiles: %v\n", err)
+		os.Exit(1)
+	}
+	srv := newServer(profiles, opts)
 
-	fmt.Printf("Server starting on http://localhost%s\n", serverPort)
-	if err := http.ListenAndServe(serverPort, nil); err != nil {
+	fmt.Printf("Server starting on http://localhost%s%s/\n", serverPort, opts.BasePath)
+	if err := http.ListenAndServe(serverPort, srv.routes()); err != nil {
 		fmt.Printf("Error starting server: %v\n", err)
 	}
 }
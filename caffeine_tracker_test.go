@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestForecastSumsDrinksInOneStep(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	now := clock.Now()
+	mustAdd(t, tracker, now.Add(5*time.Minute), 80)
+	mustAdd(t, tracker, now.Add(20*time.Minute), 60)
+	mustAdd(t, tracker, now.Add(forecastStep+time.Minute), 40)
+	clock.Advance(2 * forecastStep)
+	if _, err := tracker.Override(50); err != nil {
+		t.Fatalf("Override: %v", err)
+	}
+
+	forecast := forecastFrom(tracker.GetEvents(), now, tracker.Config())
+	if len(forecast) != forecastPoints {
+		t.Fatalf("forecast has %d points, want %d", len(forecast), forecastPoints)
+	}
+	tests := []struct {
+		point    int
+		hasDrink bool
+		amount   float64
+		first    bool
+	}{
+		{0, true, 140, true}, // Both drinks of the first step in one marker
+		{1, true, 40, false},
+		{2, false, 0, false}, // The override gets no marker
+		{3, false, 0, false},
+	}
+	for _, tt := range tests {
+		p := forecast[tt.point]
+		if p.HasDrink != tt.hasDrink || p.DrinkAmount != tt.amount || p.IsFirstOfDay != tt.first {
+			t.Errorf("point %d = hasDrink %v, %g mg, first %v; want %v, %g mg, first %v",
+				tt.point, p.HasDrink, p.DrinkAmount, p.IsFirstOfDay, tt.hasDrink, tt.amount, tt.first)
+		}
+	}
+}
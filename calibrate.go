@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	minFitHalfLife  = 0.5  // Shortest half-life in hours considered by the fit
+	maxFitHalfLife  = 24.0 // Longest half-life in hours considered by the fit
+	maxMeasurements = 100  // Most measurements accepted by /api/calibrate
+)
+
+// Measurement is a measured caffeine level at a point in time, e.g. from a
+// saliva or blood test.
+type Measurement struct {
+	Time       time.Time `json:"time"`
+	MeasuredMg float64   `json:"measuredMg"`
+}
+
+// Calibration is the result of fitting the half-life to measurements.
+type Calibration struct {
+	HalfLifeHours float64 `json:"halfLifeHours"`
+	ResidualMg    float64 `json:"residualMg"` // Root-mean-square error of the fit
+	Measurements  int     `json:"measurements"`
+}
+
+// fitHalfLife finds the global half-life that minimizes the squared error
+// between the modelled level and the measurements, given the logged drinks.
+// Per-type half-life overrides in config are kept as they are. The error is
+// searched with golden-section search between minFitHalfLife and
+// maxFitHalfLife, which assumes a single best fit in that range.
+func fitHalfLife(events []CoffeeIntakeEvent, measurements []Measurement, config Config) (Calibration, error) {
+	if len(measurements) == 0 {
+		return Calibration{}, errors.New("at least one measurement is required")
+	}
+	for _, m := range measurements {
+		if m.MeasuredMg < 0 {
+			return Calibration{}, errors.New("measuredMg must not be negative")
+		}
+	}
+	sumSquares := func(halfLife float64) float64 {
+		config.HalfLifeHours = halfLife
+		sum := 0.0
+		for _, m := range measurements {
+			diff := caffeineLevelAt(events, m.Time, config) - m.MeasuredMg
+			sum += diff * diff
+		}
+		return sum
+	}
+
+	invPhi := (math.Sqrt(5) - 1) / 2
+	lo, hi := minFitHalfLife, maxFitHalfLife
+	a, b := hi-invPhi*(hi-lo), lo+invPhi*(hi-lo)
+	fa, fb := sumSquares(a), sumSquares(b)
+	for hi-lo > 1e-4 {
+		if fa < fb {
+			hi, b, fb = b, a, fa
+			a = hi - invPhi*(hi-lo)
+			fa = sumSquares(a)
+		} else {
+			lo, a, fa = a, b, fb
+			b = lo + invPhi*(hi-lo)
+			fb = sumSquares(b)
+		}
+	}
+
+	halfLife := (lo + hi) / 2
+	return Calibration{
+		HalfLifeHours: halfLife,
+		ResidualMg:    math.Sqrt(sumSquares(halfLife) / float64(len(measurements))),
+		Measurements:  len(measurements),
+	}, nil
+}
+
+// Calibrate fits the half-life to the measurements and stores it in the
+// settings. Only the half-life is written back, so settings changed while
+// fitting are kept.
+func (t *Tracker) Calibrate(measurements []Measurement) (Calibration, error) {
+	calibration, err := fitHalfLife(t.snapshot(), measurements, t.Config())
+	if err != nil {
+		return Calibration{}, err
+	}
+	if err := t.updateConfig(func(c *Config) { c.HalfLifeHours = calibration.HalfLifeHours }); err != nil {
+		return Calibration{}, err
+	}
+	return calibration, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFitHalfLifeRecoversKnownHalfLife(t *testing.T) {
+	events := []CoffeeIntakeEvent{
+		{Time: testStart, Amount: 200},
+		{Time: testStart.Add(3 * time.Hour), Amount: 80},
+	}
+	truth := DefaultConfig()
+	truth.HalfLifeHours = 6.5
+	var measurements []Measurement
+	for _, hours := range []float64{1, 2.5, 4, 6, 9, 12} {
+		at := testStart.Add(time.Duration(hours * float64(time.Hour)))
+		measurements = append(measurements, Measurement{Time: at, MeasuredMg: caffeineLevelAt(events, at, truth)})
+	}
+
+	calibration, err := fitHalfLife(events, measurements, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(calibration.HalfLifeHours-6.5) > 0.01 {
+		t.Errorf("HalfLifeHours = %v, want 6.5", calibration.HalfLifeHours)
+	}
+	if calibration.ResidualMg > 0.01 || calibration.Measurements != len(measurements) {
+		t.Errorf("fit = %+v, want a residual near 0 over %d measurements", calibration, len(measurements))
+	}
+
+	// A measurement off by 10 mg shows up in the residual
+	measurements[2].MeasuredMg += 10
+	noisy, err := fitHalfLife(events, measurements, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if noisy.ResidualMg < 1 || math.Abs(noisy.HalfLifeHours-6.5) > 1 {
+		t.Errorf("noisy fit = %+v, want a half-life near 6.5 h with a visible residual", noisy)
+	}
+}
+
+func TestCalibrateOnlyChangesTheHalfLife(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	mustAdd(t, tracker, testStart.Add(-2*time.Hour), 200)
+	config := tracker.Config()
+	config.DailyLimitMg = 250
+	if err := tracker.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	calibration, err := tracker.Calibrate([]Measurement{{Time: testStart, MeasuredMg: 150}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tracker.Config()
+	if got.HalfLifeHours != calibration.HalfLifeHours || got.DailyLimitMg != 250 {
+		t.Errorf("config after Calibrate = half-life %v, limit %v; want %v and 250", got.HalfLifeHours, got.DailyLimitMg, calibration.HalfLifeHours)
+	}
+}
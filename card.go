@@ -0,0 +1,53 @@
+package main
+
+import "time"
+
+// cardSparklinePoints is the number of levels in a forecast card's sparkline.
+const cardSparklinePoints = 20
+
+// ForecastCard is a compact summary of the day for sharing, e.g. rendered
+// as an image. Its fields are kept stable so renderers don't break.
+type ForecastCard struct {
+	GeneratedAt  time.Time  `json:"generatedAt"`
+	Unit         string     `json:"unit"`
+	CurrentLevel float64    `json:"currentLevel"`
+	Peak         LevelPoint `json:"peak"`    // Highest level over the next 24 hours
+	Bedtime      *time.Time `json:"bedtime"` // Safe-to-sleep time; nil if not within the projection horizon
+	TodayTotal   float64    `json:"todayTotal"`
+	// Sparkline holds the level every SparklineStepMinutes over the next 24
+	// hours, starting at GeneratedAt.
+	Sparkline            []float64 `json:"sparkline"`
+	SparklineStepMinutes float64   `json:"sparklineStepMinutes"`
+}
+
+// ForecastCard summarizes the current level, the forecast and today's
+// intake in mg. The levels are capped at the plausible maximum.
+func (t *Tracker) ForecastCard() ForecastCard {
+	events, config := t.snapshot(), t.Config()
+	now := t.clock.Now()
+	horizon := forecastPoints * forecastStep
+	level := func(at time.Time) float64 {
+		level, _ := config.Clamp(caffeineLevelAt(events, at, config))
+		return level
+	}
+
+	step := horizon / cardSparklinePoints
+	sparkline := make([]float64, 0, cardSparklinePoints)
+	for i := 0; i < cardSparklinePoints; i++ {
+		sparkline = append(sparkline, level(now.Add(time.Duration(i)*step)))
+	}
+
+	card := ForecastCard{
+		GeneratedAt:          now,
+		CurrentLevel:         level(now),
+		Peak:                 t.Peak(now, horizon),
+		TodayTotal:           t.Today().TotalMg,
+		Sparkline:            sparkline,
+		SparklineStepMinutes: step.Minutes(),
+	}
+	card.Peak.Caffeine, _ = config.Clamp(card.Peak.Caffeine)
+	if at, ok := t.SafeToSleepAt(); ok {
+		card.Bedtime = &at
+	}
+	return card
+}
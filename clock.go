@@ -0,0 +1,14 @@
+package main
+
+import "time"
+
+// Clock tells the current time. The tracker reads time only through its
+// Clock so tests and demos can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+)
+
+// mgPerCup is the caffeine in a typical cup of brewed coffee.
+const mgPerCup = 95.0
+
+// caffeineUnits maps each display unit to its size in mg.
+var caffeineUnits = map[string]float64{
+	"mg":  1,
+	"cup": mgPerCup,
+}
+
+// weekdays maps the accepted weekStart values to days of the week.
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// DefaultDrink is the user's usual drink, logged when no amount is given.
+type DefaultDrink struct {
+	Amount float64 `json:"amount"`
+	Type   string  `json:"type,omitempty"`
+	Name   string  `json:"name,omitempty"`
+}
+
+// Config holds the user-tunable settings of a Tracker.
+type Config struct {
+	// RoundTo is the number of decimal places reported for caffeine values.
+	RoundTo int `json:"roundTo"`
+	// Timezone is the IANA zone used for calendar days; empty means server local time.
+	Timezone string `json:"timezone"`
+	// ResetHour is the local hour (0-23) at which a new stats day begins.
+	ResetHour int `json:"resetHour"`
+	// HalfLifeHours is the caffeine elimination half-life.
+	HalfLifeHours float64 `json:"halfLifeHours"`
+	// AbsorptionMinutes is the absorption half-life from gut to blood. 0
+	// means instant absorption; see absorption.go for the model.
+	AbsorptionMinutes float64 `json:"absorptionMinutes"`
+	// TypeHalfLives overrides HalfLifeHours for specific drink types.
+	TypeHalfLives map[string]float64 `json:"typeHalfLives"`
+	// AdaptiveHalfLife shortens all half-lives for heavy regular intake,
+	// within fixed bounds (see adaptive.go). Off by default.
+	AdaptiveHalfLife bool `json:"adaptiveHalfLife"`
+	// halfLifeScale is the current adaptive factor on all half-lives, kept
+	// up to date by the Tracker; 0 means 1.
+	halfLifeScale float64
+	// SleepThresholdMg is the level at or below which it is safe to sleep.
+	SleepThresholdMg float64 `json:"sleepThresholdMg"`
+	// AlertFloorMg is the level at or above which the user is alert enough
+	// for tasks like driving.
+	AlertFloorMg float64 `json:"alertFloorMg"`
+	// MaxEvents caps the number of stored events; once exceeded the oldest
+	// are deleted. 0 means unbounded. The oldest events have decayed the
+	// most, so this rarely changes the current level or forecast, but it
+	// does shorten the history that stats are computed from.
+	MaxEvents int `json:"maxEvents"`
+	// DefaultDrink is logged by add-coffee requests without an amount; nil
+	// means an amount is always required.
+	DefaultDrink *DefaultDrink `json:"defaultDrink"`
+	// Drinks are the user's own entries for looking up a drink's amount by
+	// name, checked along with the built-in table (see lookup.go).
+	Drinks []KnownDrink `json:"drinks"`
+	// MaxPlausibleMg caps reported caffeine levels so heavy days don't
+	// produce implausible values that flatten charts. 0 means no cap.
+	// Stored events and projections are unaffected.
+	MaxPlausibleMg float64 `json:"maxPlausibleMg"`
+	// WeekStart is the first day of the week for weekly stats, e.g. "monday".
+	WeekStart string `json:"weekStart"`
+	// DailyLimitMg is the daily caffeine budget.
+	DailyLimitMg float64 `json:"dailyLimitMg"`
+	// WarnAtFraction is the share of DailyLimitMg at which a heads-up is
+	// given, before the limit itself is reached. 0 disables it.
+	WarnAtFraction float64 `json:"warnAtFraction"`
+	// GraceMg is intake per stats day that doesn't count toward
+	// DailyLimitMg, e.g. a morning espresso treated as free.
+	GraceMg float64 `json:"graceMg"`
+	// SnoozeUntil suppresses warnings until this time; nil when not
+	// snoozed. It is cleared once it has passed.
+	SnoozeUntil *time.Time `json:"snoozeUntil"`
+	// DisplayUnit is the unit caffeine amounts are reported in: "mg" or "cup".
+	// Settings such as thresholds are always in mg.
+	DisplayUnit string `json:"displayUnit"`
+	// MinIntervalMinutes is the shortest advised gap between drinks; a drink
+	// logged sooner is still stored but comes with a warning. 0 disables
+	// the check.
+	MinIntervalMinutes int `json:"minIntervalMinutes"`
+	// WiredMaxMg is the level at which /api/wiredness reads 1: the most
+	// caffeine that still feels comfortable.
+	WiredMaxMg float64 `json:"wiredMaxMg"`
+	// WiredExponent shapes the wiredness curve (level/WiredMaxMg)^exponent:
+	// 1 is linear, below 1 rises quickly at low levels, above 1 slowly.
+	WiredExponent float64 `json:"wiredExponent"`
+	// Goal is the personal caffeine target tracked by /api/goal; nil when
+	// none is set.
+	Goal *Goal `json:"goal"`
+	// HourlySensitivity holds a multiplier for each local hour of the day
+	// (index 0 is midnight), applied to the level for sleep recommendations,
+	// e.g. 1.5 in the evening to react more strongly to caffeine then. Empty
+	// means 1 around the clock.
+	HourlySensitivity []float64 `json:"hourlySensitivity"`
+	// LastCallHour is the local hour (1-23) from which drinks are too late
+	// for a good night's sleep, until the next ResetHour. A later drink is
+	// logged with a warning, or rejected if LastCallStrict is set. 0
+	// disables the check.
+	LastCallHour   int  `json:"lastCallHour"`
+	LastCallStrict bool `json:"lastCallStrict"`
+	// IntakeWindowMinutes is the length of the rolling window in which
+	// intake is checked against IntakeWindowLimitMg.
+	IntakeWindowMinutes int `json:"intakeWindowMinutes"`
+	// IntakeWindowLimitMg is the most caffeine advised within one intake
+	// window; a drink that takes the window's total over it is logged with a
+	// warning. This is about how fast caffeine is taken, not the level. 0
+	// disables the check.
+	IntakeWindowLimitMg float64 `json:"intakeWindowLimitMg"`
+	// RestoreWindowHours is how long a deleted drink can be restored
+	// before it is purged for good. 0 deletes drinks for good at once.
+	RestoreWindowHours int `json:"restoreWindowHours"`
+	// DecayModel is how each drink's level decays: "exponential", or
+	// "linear-tail" to switch to linear decay below TailCrossoverMg so small
+	// amounts run out in bounded time. See absorption.go.
+	DecayModel      string  `json:"decayModel"`
+	TailCrossoverMg float64 `json:"tailCrossoverMg"`
+	// WakeTime and Bedtime are the usual local times ("07:00", "23:00") the
+	// user gets up and goes to bed, marked on the forecast. Empty means
+	// not set.
+	WakeTime string `json:"wakeTime"`
+	Bedtime  string `json:"bedtime"`
+	// SleepHalfLifeHours replaces HalfLifeHours between Bedtime and
+	// WakeTime, for caffeine metabolised at another pace during sleep. 0
+	// keeps the awake half-life (see sleepdecay.go).
+	SleepHalfLifeHours float64 `json:"sleepHalfLifeHours"`
+}
+
+// DefaultConfig returns the built-in settings.
+func DefaultConfig() Config {
+	return Config{
+		RoundTo:          1,
+		ResetHour:        4,
+		HalfLifeHours:    5,
+		TypeHalfLives:    map[string]float64{},
+		SleepThresholdMg: 50,
+		AlertFloorMg:     40,
+		WeekStart:        "monday",
+		DailyLimitMg:     400,
+		WarnAtFraction:   0.8,
+		DisplayUnit:      "mg",
+		WiredMaxMg:       300,
+		WiredExponent:    1,
+		// EFSA considers single doses up to 200 mg safe for healthy adults
+		IntakeWindowMinutes: 60,
+		IntakeWindowLimitMg: 200,
+		RestoreWindowHours:  24,
+		DecayModel:          decayExponential,
+		TailCrossoverMg:     10,
+	}
+}
+
+// Validate reports whether the settings are usable.
+func (c Config) Validate() error {
+	if c.RoundTo < 0 || c.RoundTo > 10 {
+		return errors.New("roundTo must be between 0 and 10")
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", c.Timezone)
+	}
+	if c.ResetHour < 0 || c.ResetHour > 23 {
+		return errors.New("resetHour must be between 0 and 23")
+	}
+	if c.HalfLifeHours <= 0 {
+		return errors.New("halfLifeHours must be positive")
+	}
+	if c.AbsorptionMinutes < 0 || c.AbsorptionMinutes > 240 {
+		return errors.New("absorptionMinutes must be between 0 and 240")
+	}
+	if _, ok := caffeineUnits[c.DisplayUnit]; !ok {
+		return fmt.Errorf("displayUnit must be \"mg\" or \"cup\", got %q", c.DisplayUnit)
+	}
+	if c.SleepThresholdMg < 0 {
+		return errors.New("sleepThresholdMg must not be negative")
+	}
+	if c.MaxEvents < 0 {
+		return errors.New("maxEvents must not be negative")
+	}
+	if c.DefaultDrink != nil && c.DefaultDrink.Amount <= 0 {
+		return errors.New("defaultDrink amount must be positive")
+	}
+	for _, drink := range c.Drinks {
+		if len(nameWords(drink.Name)) == 0 {
+			return errors.New("drinks must have a name")
+		}
+		if drink.Amount <= 0 {
+			return fmt.Errorf("amount of drink %q must be positive", drink.Name)
+		}
+	}
+	if c.AlertFloorMg < 0 {
+		return errors.New("alertFloorMg must not be negative")
+	}
+	if _, ok := weekdays[c.WeekStart]; !ok {
+		return fmt.Errorf("weekStart must be a day of the week such as \"monday\", got %q", c.WeekStart)
+	}
+	if c.MaxPlausibleMg < 0 {
+		return errors.New("maxPlausibleMg must not be negative")
+	}
+	if c.DailyLimitMg <= 0 {
+		return errors.New("dailyLimitMg must be positive")
+	}
+	if c.WarnAtFraction < 0 || c.WarnAtFraction > 1 {
+		return errors.New("warnAtFraction must be between 0 and 1")
+	}
+	if c.GraceMg < 0 {
+		return errors.New("graceMg must not be negative")
+	}
+	if c.MinIntervalMinutes < 0 {
+		return errors.New("minIntervalMinutes must not be negative")
+	}
+	if c.WiredMaxMg <= 0 {
+		return errors.New("wiredMaxMg must be positive")
+	}
+	if c.WiredExponent <= 0 {
+		return errors.New("wiredExponent must be positive")
+	}
+	if c.Goal != nil {
+		if err := c.Goal.validate(); err != nil {
+			return err
+		}
+	}
+	if c.LastCallHour < 0 || c.LastCallHour > 23 {
+		return errors.New("lastCallHour must be between 0 and 23")
+	}
+	if c.LastCallHour != 0 && c.LastCallHour == c.ResetHour {
+		return errors.New("lastCallHour must differ from resetHour")
+	}
+	if c.IntakeWindowMinutes <= 0 {
+		return errors.New("intakeWindowMinutes must be positive")
+	}
+	if c.IntakeWindowLimitMg < 0 {
+		return errors.New("intakeWindowLimitMg must not be negative")
+	}
+	if c.RestoreWindowHours < 0 {
+		return errors.New("restoreWindowHours must not be negative")
+	}
+	if !slices.Contains(decayModels, c.DecayModel) {
+		return fmt.Errorf("decayModel must be \"exponential\" or \"linear-tail\", got %q", c.DecayModel)
+	}
+	if c.TailCrossoverMg <= 0 {
+		return errors.New("tailCrossoverMg must be positive")
+	}
+	if c.SleepHalfLifeHours < 0 {
+		return errors.New("sleepHalfLifeHours must not be negative")
+	}
+	for name, hhmm := range map[string]string{"wakeTime": c.WakeTime, "bedtime": c.Bedtime} {
+		if _, err := time.Parse("15:04", hhmm); hhmm != "" && err != nil {
+			return fmt.Errorf("%s must be a time like \"07:00\", got %q", name, hhmm)
+		}
+	}
+	if n := len(c.HourlySensitivity); n != 0 && n != 24 {
+		return fmt.Errorf("hourlySensitivity must have 24 entries, one per hour, got %d", n)
+	}
+	for hour, multiplier := range c.HourlySensitivity {
+		if multiplier <= 0 {
+			return fmt.Errorf("hourlySensitivity for hour %d must be positive", hour)
+		}
+	}
+	for drinkType, halfLife := range c.TypeHalfLives {
+		if halfLife <= 0 {
+			return fmt.Errorf("half-life for %q must be positive", drinkType)
+		}
+	}
+	return nil
+}
+
+// HalfLifeFor returns the half-life in hours for a drink type, falling back
+// to the global half-life when the type has no override, with the adaptive
+// scale applied.
+func (c Config) HalfLifeFor(drinkType string) float64 {
+	halfLife, ok := c.TypeHalfLives[drinkType]
+	if !ok {
+		halfLife = c.HalfLifeHours
+	}
+	return halfLife * c.halfLifeFactor()
+}
+
+// halfLifeFactor is the adaptive factor on all half-lives: halfLifeScale
+// if AdaptiveHalfLife is on, otherwise 1.
+func (c Config) halfLifeFactor() float64 {
+	if c.AdaptiveHalfLife && c.halfLifeScale > 0 {
+		return c.halfLifeScale
+	}
+	return 1
+}
+
+// clone returns a copy that shares no maps with c.
+func (c Config) clone() Config {
+	c.TypeHalfLives = maps.Clone(c.TypeHalfLives)
+	c.HourlySensitivity = slices.Clone(c.HourlySensitivity)
+	c.Drinks = slices.Clone(c.Drinks)
+	if c.DefaultDrink != nil {
+		usual := *c.DefaultDrink
+		c.DefaultDrink = &usual
+	}
+	if c.SnoozeUntil != nil {
+		until := *c.SnoozeUntil
+		c.SnoozeUntil = &until
+	}
+	if c.Goal != nil {
+		goal := *c.Goal
+		c.Goal = &goal
+	}
+	return c
+}
+
+// Location returns the configured timezone, falling back to server local time.
+func (c Config) Location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// SensitivityAt returns the HourlySensitivity multiplier for the local hour
+// of at.
+func (c Config) SensitivityAt(at time.Time) float64 {
+	if len(c.HourlySensitivity) != 24 {
+		return 1
+	}
+	return c.HourlySensitivity[at.In(c.Location()).Hour()]
+}
+
+// Wiredness maps a caffeine level in mg onto a 0-1 scale for gauges, using
+// WiredMaxMg and WiredExponent.
+func (c Config) Wiredness(level float64) float64 {
+	ratio := math.Min(math.Max(level/c.WiredMaxMg, 0), 1)
+	return math.Pow(ratio, c.WiredExponent)
+}
+
+// Clamp caps a caffeine level at MaxPlausibleMg and reports whether it did.
+func (c Config) Clamp(level float64) (float64, bool) {
+	if c.MaxPlausibleMg > 0 && level > c.MaxPlausibleMg {
+		return c.MaxPlausibleMg, true
+	}
+	return level, false
+}
+
+// Round rounds a caffeine value for output. Only apply it to serialized
+// values; internal calculations keep full precision.
+func (c Config) Round(v float64) float64 {
+	scale := math.Pow(10, float64(c.RoundTo))
+	return math.Round(v*scale) / scale
+}
+
+// Display converts a caffeine amount in mg to the display unit and rounds it
+// for output. Like Round, it is only for serialized values.
+func (c Config) Display(mg float64) float64 {
+	factor, ok := caffeineUnits[c.DisplayUnit]
+	if !ok {
+		factor = 1
+	}
+	return c.Round(mg / factor)
+}
+
+// Thresholds are the configured levels a client draws as reference lines,
+// in the display unit.
+type Thresholds struct {
+	Sleep      float64 `json:"sleep"`      // SleepThresholdMg
+	AlertFloor float64 `json:"alertFloor"` // AlertFloorMg
+	DailyLimit float64 `json:"dailyLimit"` // DailyLimitMg; a daily total rather than a level
+}
+
+// DisplayThresholds returns the thresholds converted for output.
+func (c Config) DisplayThresholds() Thresholds {
+	return Thresholds{
+		Sleep:      c.Display(c.SleepThresholdMg),
+		AlertFloor: c.Display(c.AlertFloorMg),
+		DailyLimit: c.Display(c.DailyLimitMg),
+	}
+}
+
+// Config returns the tracker's current settings.
+func (t *Tracker) Config() Config {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expireSnoozeLocked()
+	return t.config.clone()
+}
+
+// SetConfig validates and replaces the tracker's settings. Moving the
+// stats day to another reset hour or timezone drops the daily rollups.
+func (t *Tracker) SetConfig(config Config) error {
+	return t.updateConfig(func(c *Config) { *c = config })
+}
+
+// updateConfig applies update to a copy of the settings under the lock and
+// stores the result if it is valid, so fields update leaves alone can't be
+// overwritten with stale values by a concurrent change.
+func (t *Tracker) updateConfig(update func(*Config)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	config := t.config.clone()
+	update(&config)
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	old := t.config
+	t.config = config.clone()
+	t.dropMovedRollupsLocked(old)
+	if _, err := t.updateHalfLifeScaleLocked(); err != nil {
+		fmt.Printf("Error adapting the half-life: %v\n", err)
+	}
+	t.version++
+	t.notifier.Notify()
+	return nil
+}
+
+// ResetConfig restores the built-in default settings and returns them.
+// Events are left untouched.
+func (t *Tracker) ResetConfig() Config {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old := t.config
+	t.config = DefaultConfig()
+	t.dropMovedRollupsLocked(old)
+	t.version++
+	t.notifier.Notify()
+	return t.config.clone()
+}
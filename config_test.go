@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		roundTo int
+		v, want float64
+	}{
+		{1, 94.99999999999997, 95},
+		{1, 87.05505632961241, 87.1},
+		{0, 87.5, 88},
+		{2, 0.125, 0.13},
+		{3, 12.3456, 12.346},
+	}
+	for _, tt := range tests {
+		config := DefaultConfig()
+		config.RoundTo = tt.roundTo
+		if got := config.Round(tt.v); got != tt.want {
+			t.Errorf("Round(%v) with roundTo %d = %v, want %v", tt.v, tt.roundTo, got, tt.want)
+		}
+	}
+}
+
+func TestLevelIsRoundedInJSON(t *testing.T) {
+	store := newMemoryStore()
+	store.Add(CoffeeIntakeEvent{Time: time.Now().Add(-time.Hour), Amount: 100})
+	tracker := NewTrackerWithStore(store, systemClock{})
+	handler := newTestServer(t, tracker)
+
+	// 100 mg after an hour with a 5 h half-life is 87.055... mg
+	rec := do(handler, http.MethodGet, "/api/caffeine-level", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Level float64 `json:"level"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding level: %v", err)
+	}
+	if resp.Level != math.Round(resp.Level*10)/10 || math.Abs(resp.Level-87.06) > 0.1 {
+		t.Errorf("level = %v, want about 87.1 with one decimal place", resp.Level)
+	}
+
+	rec = do(handler, http.MethodGet, "/api/forecast", nil)
+	var forecast []ForecastPoint
+	if err := json.Unmarshal(rec.Body.Bytes(), &forecast); err != nil {
+		t.Fatalf("decoding forecast: %v", err)
+	}
+	for _, point := range forecast {
+		if point.Caffeine != math.Round(point.Caffeine*10)/10 {
+			t.Fatalf("forecast point at %v has caffeine %v, want one decimal place", point.Time, point.Caffeine)
+		}
+	}
+}
+
+func TestDisplayUnits(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	mustAdd(t, tracker, clock.Now(), 190)
+	handler := newTestServer(t, tracker)
+
+	type response struct {
+		Level   float64 `json:"level"`
+		TotalMg float64 `json:"totalMg"`
+		Unit    string  `json:"unit"`
+	}
+	get := func(target string) (response, string) {
+		t.Helper()
+		rec := do(handler, http.MethodGet, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", target, rec.Code, rec.Body)
+		}
+		var resp response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		return resp, rec.Header().Get("X-Caffeine-Unit")
+	}
+	setUnit := func(unit string) {
+		t.Helper()
+		config := tracker.Config()
+		config.DisplayUnit = unit
+		config.RoundTo = 3
+		if err := tracker.SetConfig(config); err != nil {
+			t.Fatalf("SetConfig(%s): %v", unit, err)
+		}
+	}
+
+	setUnit("mg")
+	mgLevel, _ := get("/api/caffeine-level")
+	mgToday, _ := get("/api/today")
+	mgSummary, _ := get("/api/summary")
+
+	setUnit("cup")
+	cupLevel, header := get("/api/caffeine-level")
+	cupToday, _ := get("/api/today")
+	cupSummary, _ := get("/api/summary")
+
+	if header != "cup" || cupLevel.Unit != "cup" || cupToday.Unit != "cup" || cupSummary.Unit != "cup" {
+		t.Errorf("units = header %q, level %q, today %q, summary %q, want cup", header, cupLevel.Unit, cupToday.Unit, cupSummary.Unit)
+	}
+	for _, tt := range []struct {
+		name    string
+		mg, cup float64
+	}{
+		{"level", mgLevel.Level, cupLevel.Level},
+		{"today", mgToday.TotalMg, cupToday.TotalMg},
+		{"summary", mgSummary.TotalMg, cupSummary.TotalMg},
+	} {
+		if want := math.Round(tt.mg/mgPerCup*1000) / 1000; tt.cup != want {
+			t.Errorf("%s = %v cups for %v mg, want %v", tt.name, tt.cup, tt.mg, want)
+		}
+	}
+	if cupToday.TotalMg != 2 {
+		t.Errorf("today = %v cups, want 2 for 190 mg", cupToday.TotalMg)
+	}
+
+	config := tracker.Config()
+	config.DisplayUnit = "mug"
+	if err := tracker.SetConfig(config); err == nil {
+		t.Error("SetConfig accepted displayUnit \"mug\"")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "time"
+
+// curveMinMg is the contribution below which a drink past its peak is left
+// out of the curve parameters; it can only shrink from there.
+const curveMinMg = 1.0
+
+// CurveParams is everything a client needs to compute the caffeine level
+// curve itself with the model described by Model, e.g. to animate a chart
+// between polls. Amounts are in mg.
+type CurveParams struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	Version     uint64    `json:"version"` // Refetch once /api/poll or /api/stream reports another version
+	Model       ModelInfo `json:"model"`
+	// MaxPlausibleMg caps the reported level; 0 means no cap.
+	MaxPlausibleMg float64 `json:"maxPlausibleMg"`
+	// Unit and MgPerUnit convert levels to the display unit.
+	Unit      string       `json:"unit"`
+	MgPerUnit float64      `json:"mgPerUnit"`
+	Events    []CurveEvent `json:"events"`
+}
+
+// CurveEvent is a drink as input to the level curve.
+type CurveEvent struct {
+	Time          time.Time `json:"time"`
+	Amount        float64   `json:"amount"`
+	HalfLifeHours float64   `json:"halfLifeHours"`          // Already resolved from typeHalfLives
+	EmptyStomach  bool      `json:"emptyStomach,omitempty"` // Only matters in the two-compartment model
+}
+
+// CurveParams returns the inputs to the level curve from now on: the drinks
+// still contributing at least curveMinMg, still being absorbed or logged for
+// later, with the model settings.
+func (t *Tracker) CurveParams() CurveParams {
+	version := t.Version()
+	events, config := t.snapshot(), t.Config()
+	now := t.clock.Now()
+	params := CurveParams{
+		GeneratedAt:    now,
+		Version:        version,
+		Model:          config.Model(),
+		MaxPlausibleMg: config.MaxPlausibleMg,
+		Unit:           config.DisplayUnit,
+		MgPerUnit:      caffeineUnits[config.DisplayUnit],
+		Events:         make([]CurveEvent, 0),
+	}
+	for _, event := range events {
+		hours := now.Sub(event.Time).Hours()
+		if hours >= peakHours(event, config) && eventLevel(event, hours, config) < curveMinMg {
+			continue
+		}
+		params.Events = append(params.Events, CurveEvent{
+			Time:          event.Time,
+			Amount:        event.Amount,
+			HalfLifeHours: config.HalfLifeFor(event.Type),
+			EmptyStomach:  event.EmptyStomach,
+		})
+	}
+	return params
+}
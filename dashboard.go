@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// dashboardDays is how many finished days the dashboard lists.
+const dashboardDays = 30
+
+// Dashboard holds every stats section of the stats screen, computed from
+// one snapshot of the events so the numbers agree with each other.
+type Dashboard struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Unit        string           `json:"unit,omitempty"` // Unit of the amounts in responses
+	Today       DayStats         `json:"today"`
+	Budget      Budget           `json:"budget"`
+	Summary     Summary          `json:"summary"`
+	Record      *RecordDay       `json:"record"` // nil if no drinks are logged
+	Weekly      WeeklyComparison `json:"weekly"`
+	Daily       []DayRollup      `json:"daily"` // The last dashboardDays finished days, oldest first
+}
+
+// Dashboard computes the stats sections from one snapshot, using calendar
+// days in loc. Today and the budget follow the configured stats day.
+func (t *Tracker) Dashboard(loc *time.Location) Dashboard {
+	events, config := t.snapshot(), t.Config()
+	now := t.clock.Now()
+	dayStart := resetBoundary(now, config.ResetHour, config.Location())
+	today := startOfDay(now, loc)
+
+	dashboard := Dashboard{
+		GeneratedAt: now,
+		Today:       dayStats(events, dayStart),
+		Budget:      budgetFrom(events, dayStart, config),
+		Summary:     summarize(events, now, loc),
+		Weekly:      weeklyComparison(events, config, now, loc),
+		Daily:       make([]DayRollup, 0),
+	}
+	if record, ok := recordDay(events, config, now, loc); ok {
+		dashboard.Record = &record
+	}
+	if days := dailyRollups(events, today, 0, loc); len(days) > dashboardDays {
+		dashboard.Daily = days[len(days)-dashboardDays:]
+	} else if days != nil {
+		dashboard.Daily = days
+	}
+	return dashboard
+}
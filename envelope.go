@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// responseMeta is the debugging context added to enveloped responses.
+type responseMeta struct {
+	ServerTime time.Time `json:"serverTime"`
+	Version    uint64    `json:"version"`
+	EventCount int       `json:"eventCount"`
+}
+
+// dataEnvelope wraps a successful JSON response when ?envelope=true.
+type dataEnvelope struct {
+	Data any          `json:"data"`
+	Meta responseMeta `json:"meta"`
+}
+
+// errorEnvelope wraps an error response when ?envelope=true.
+type errorEnvelope struct {
+	Error string       `json:"error"`
+	Meta  responseMeta `json:"meta"`
+}
+
+// envelopeWriter marks a response whose JSON should be enveloped. writeJSON
+// does the wrapping; plain-text errors written with http.Error are caught
+// here and rewritten as an errorEnvelope once the handler returns. Other
+// non-JSON bodies (CSV, calendars, streams) pass through unchanged.
+type envelopeWriter struct {
+	http.ResponseWriter
+	meta   func() responseMeta
+	status int          // Status of a caught error, 0 if none
+	errMsg bytes.Buffer // Body of a caught error
+}
+
+func (w *envelopeWriter) WriteHeader(code int) {
+	if code >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *envelopeWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.errMsg.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *envelopeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *envelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes a caught error as an errorEnvelope.
+func (w *envelopeWriter) finish() {
+	if w.status == 0 {
+		return
+	}
+	w.Header().Del("X-Content-Type-Options")
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	newJSONEncoder(w.ResponseWriter).Encode(errorEnvelope{
+		Error: strings.TrimSpace(w.errMsg.String()),
+		Meta:  w.meta(),
+	})
+}
+
+// envelopeResponses wraps JSON responses in {data, meta} and errors in
+// {error, meta} for requests with ?envelope=true. Other requests get the
+// bare payload.
+func (s *server) envelopeResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("envelope") != "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &envelopeWriter{ResponseWriter: w, meta: func() responseMeta { return s.responseMeta(r) }}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
+
+// responseMeta describes the state of the request's tracker at the time
+// of the response.
+func (s *server) responseMeta(r *http.Request) responseMeta {
+	tracker := s.trackerFor(r)
+	return responseMeta{
+		ServerTime: tracker.Now(),
+		Version:    tracker.Version(),
+		EventCount: tracker.EventCount(),
+	}
+}
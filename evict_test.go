@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMaxEventsEvictsTheOldest(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	config := tracker.Config()
+	config.MaxEvents = 3
+	if err := tracker.SetConfig(config); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+
+	start := clock.Now().Add(-10 * time.Hour)
+	for i := range 5 {
+		mustAdd(t, tracker, start.Add(time.Duration(i)*time.Hour), float64(10*(i+1)))
+	}
+	assertAmounts(t, tracker, 30, 40, 50)
+
+	// A backdated drink is the oldest, so it goes first
+	mustAdd(t, tracker, start.Add(-time.Hour), 5)
+	assertAmounts(t, tracker, 30, 40, 50)
+
+	mustAdd(t, tracker, clock.Now(), 60)
+	assertAmounts(t, tracker, 40, 50, 60)
+}
+
+func TestMaxEventsZeroIsUnbounded(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	for i := range 50 {
+		mustAdd(t, tracker, clock.Now().Add(-time.Duration(i)*time.Minute), 1)
+	}
+	if got := len(tracker.GetEvents()); got != 50 {
+		t.Errorf("%d events kept, want all 50", got)
+	}
+}
+
+// assertAmounts checks the amounts of the tracker's events in time order.
+func assertAmounts(t *testing.T, tracker *Tracker, want ...float64) {
+	t.Helper()
+	events := tracker.GetEvents()
+	got := make([]float64, len(events))
+	for i, event := range events {
+		got[i] = event.Amount
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("amounts = %v, want %v", got, want)
+	}
+}
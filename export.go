@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader is the column layout of CSV exports and imports.
+var csvHeader = []string{"id", "time", "amount", "type", "name", "tags", "emptyStomach", "note"}
+
+const (
+	csvTagSeparator = ";"  // Joins an event's tags within the tags column
+	csvFlushRows    = 1000 // Rows written between flushes to the client
+)
+
+// WriteCSV writes events as CSV with a header row. Amounts are written in
+// plain decimal notation with as many digits as needed to round-trip
+// exactly, never in scientific notation.
+//
+// Rows are streamed: only a small buffer is held, and if w is an
+// http.Flusher it is flushed every csvFlushRows rows so large exports
+// reach the client as they are written.
+func WriteCSV(w io.Writer, events []CoffeeIntakeEvent) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	flusher, _ := w.(http.Flusher)
+	record := make([]string, len(csvHeader))
+	for i, event := range events {
+		record[0] = event.ID
+		record[1] = event.Time.Format(time.RFC3339Nano)
+		record[2] = strconv.FormatFloat(event.Amount, 'f', -1, 64)
+		record[3] = event.Type
+		record[4] = event.Name
+		record[5] = strings.Join(event.Tags, csvTagSeparator)
+		record[6] = ""
+		if event.EmptyStomach {
+			record[6] = "true"
+		}
+		record[7] = event.Note
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		if flusher != nil && (i+1)%csvFlushRows == 0 {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadCSV parses a CSV export. Rows that can't be parsed or fail validation
+// are reported as skipped; their index counts data rows from 0.
+func ReadCSV(r io.Reader) ([]CoffeeIntakeEvent, []SkippedRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("empty CSV")
+		}
+		return nil, nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"time", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("CSV is missing the %q column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	events := make([]CoffeeIntakeEvent, 0)
+	skipped := make([]SkippedRecord, 0)
+	for i := 0; ; i++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				skipped = append(skipped, SkippedRecord{Index: i, Reason: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+
+		event, err := parseCSVRecord(field(record, "time"), field(record, "amount"))
+		if err == nil {
+			event.Note = field(record, "note")
+			err = validateImported(event)
+		}
+		if err != nil {
+			skipped = append(skipped, SkippedRecord{Index: i, Reason: err.Error()})
+			continue
+		}
+		event.Type = field(record, "type")
+		event.Name = field(record, "name")
+		if tags := field(record, "tags"); tags != "" {
+			event.Tags = strings.Split(tags, csvTagSeparator)
+		}
+		event.EmptyStomach = field(record, "emptyStomach") == "true"
+		events = append(events, event)
+	}
+	return events, skipped, nil
+}
+
+func parseCSVRecord(timeField, amountField string) (CoffeeIntakeEvent, error) {
+	at, err := time.Parse(time.RFC3339Nano, timeField)
+	if err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("invalid time %q", timeField)
+	}
+	amount, err := strconv.ParseFloat(amountField, 64)
+	if err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("invalid amount %q", amountField)
+	}
+	return CoffeeIntakeEvent{Time: at, Amount: amount}, nil
+}
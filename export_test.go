@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFractionalAmountsRoundTrip(t *testing.T) {
+	amounts := []float64{12.5, 0.25, 0.0000025, 1234567.125}
+	for _, format := range []string{"json", "csv"} {
+		tracker, _ := newTestTracker(t)
+		handler := newTestServer(t, tracker)
+		for _, amount := range amounts {
+			body, _ := json.Marshal(map[string]float64{"amount": amount})
+			if rec := do(handler, http.MethodPost, "/api/add-coffee", bytes.NewReader(body)); rec.Code != http.StatusOK {
+				t.Fatalf("adding %v: status %d: %s", amount, rec.Code, rec.Body)
+			}
+		}
+
+		rec := do(handler, http.MethodGet, "/api/events", nil)
+		var listed []CoffeeIntakeEvent
+		if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+			t.Fatalf("decoding events: %v", err)
+		}
+		for i, event := range listed {
+			if event.Amount != amounts[i] {
+				t.Errorf("listed amount %v, want %v", event.Amount, amounts[i])
+			}
+		}
+
+		export := do(handler, http.MethodGet, "/api/export?format="+format, nil)
+		if export.Code != http.StatusOK {
+			t.Fatalf("%s export: status %d: %s", format, export.Code, export.Body)
+		}
+		if format == "csv" {
+			records, err := csv.NewReader(bytes.NewReader(export.Body.Bytes())).ReadAll()
+			if err != nil {
+				t.Fatalf("reading CSV export: %v", err)
+			}
+			for _, record := range records[1:] {
+				if strings.ContainsAny(record[2], "eE") {
+					t.Errorf("CSV amount %q uses scientific notation", record[2])
+				}
+			}
+		}
+
+		restored, _ := newTestTracker(t)
+		req := httptest.NewRequest(http.MethodPost, "/api/import", bytes.NewReader(export.Body.Bytes()))
+		req.Header.Set("Content-Type", export.Header().Get("Content-Type"))
+		rec = httptest.NewRecorder()
+		newTestServer(t, restored).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s import: status %d: %s", format, rec.Code, rec.Body)
+		}
+		events := restored.GetEvents()
+		if len(events) != len(amounts) {
+			t.Fatalf("%s: imported %d events, want %d", format, len(events), len(amounts))
+		}
+		for i, event := range events {
+			if event.Amount != amounts[i] {
+				t.Errorf("%s: imported amount %v, want %v", format, event.Amount, amounts[i])
+			}
+		}
+	}
+}
+
+// flushCounter is an http.Flusher that records the largest single write
+// and the number of flushes.
+type flushCounter struct {
+	written, largest, flushes int
+}
+
+func (w *flushCounter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	w.largest = max(w.largest, len(p))
+	return len(p), nil
+}
+
+func (w *flushCounter) Flush() { w.flushes++ }
+
+func TestWriteCSVStreams(t *testing.T) {
+	const rows = 50000
+	ids := newIDGenerator()
+	events := make([]CoffeeIntakeEvent, rows)
+	for i := range events {
+		events[i] = CoffeeIntakeEvent{
+			ID:     ids.Next(testStart),
+			Time:   testStart.Add(time.Duration(i) * time.Minute),
+			Amount: 12.5,
+			Type:   "coffee",
+			Tags:   []string{"work", "morning"},
+		}
+	}
+
+	w := &flushCounter{}
+	if err := WriteCSV(w, events); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	// Rows reach the writer in small chunks rather than one buffer of the
+	// whole export
+	if w.largest > 8<<10 || w.written < 100*w.largest {
+		t.Errorf("largest write %d bytes of %d, want the export streamed", w.largest, w.written)
+	}
+	if want := rows / csvFlushRows; w.flushes != want {
+		t.Errorf("%d flushes, want %d", w.flushes, want)
+	}
+}
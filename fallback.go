@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// storeRetryInterval is how often a degraded store retries its backend.
+const storeRetryInterval = 30 * time.Second
+
+// fallbackStore keeps the server usable while a persistent store is
+// unavailable. It mirrors the data in memory: everything stored when it was
+// opened plus every change made through it. While the backend works, reads
+// and writes go to the backend and writes are copied to the mirror. Once the
+// backend can't be reached, the store is degraded: it serves reads from the
+// mirror and queues writes, and retryLoop replays the queue once the backend
+// answers again.
+//
+// While degraded, changes made by other servers sharing the backend are not
+// visible.
+type fallbackStore struct {
+	mu       sync.Mutex
+	backend  Store
+	mirror   *memoryStore
+	pending  []func(Store) error // Writes not yet made to the backend, oldest first
+	degraded atomic.Bool         // Read without mu so health checks never block
+}
+
+// newFallbackStore wraps backend, loading its data into the mirror.
+func newFallbackStore(backend Store) (*fallbackStore, error) {
+	f := &fallbackStore{backend: backend, mirror: newMemoryStore()}
+	events, err := backend.Events()
+	if err != nil {
+		return nil, err
+	}
+	f.mirror.events = events
+	if f.mirror.tombstones, err = backend.Tombstones(); err != nil {
+		return nil, err
+	}
+	if f.mirror.trash, err = backend.Trash(); err != nil {
+		return nil, err
+	}
+	if f.mirror.sleep, err = backend.SleepEntries(); err != nil {
+		return nil, err
+	}
+	if f.mirror.rollups, err = backend.Rollups(); err != nil {
+		return nil, err
+	}
+	if f.mirror.scenarios, err = backend.Scenarios(); err != nil {
+		return nil, err
+	}
+	if f.mirror.profiles, err = backend.Profiles(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Degraded reports whether the backend is unavailable.
+func (f *fallbackStore) Degraded() bool {
+	return f.degraded.Load()
+}
+
+// degradeLocked switches to the mirror after the backend failed. The caller
+// must hold f.mu.
+func (f *fallbackStore) degradeLocked(err error) {
+	if !f.degraded.Swap(true) {
+		fmt.Printf("Error from store, continuing in memory until it recovers: %v\n", err)
+	}
+}
+
+// backendUnavailable reports whether err means the backend could not be
+// reached, as opposed to an error it replied with, such as a Redis error
+// reply. Only the former degrades the store.
+func backendUnavailable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// readFallback reads from the backend, or from the mirror if the store is
+// degraded or the backend can't be reached.
+func readFallback[T any](f *fallbackStore, read func(Store) (T, error)) (T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.Degraded() {
+		v, err := read(f.backend)
+		if err == nil || !backendUnavailable(err) {
+			return v, err
+		}
+		f.degradeLocked(err)
+	}
+	return read(f.mirror)
+}
+
+// writeFallback applies a write to the backend and to the mirror. While the
+// backend works, its result is returned, as it may know data the mirror
+// doesn't; an error it replies with is returned as is and the mirror is
+// left alone. If the store is degraded or the backend can't be reached, the
+// write is queued and the mirror's result is returned.
+func writeFallback[T any](f *fallbackStore, write func(Store) (T, error)) (T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.Degraded() {
+		v, err := write(f.backend)
+		if err == nil {
+			write(f.mirror)
+			return v, nil
+		}
+		if !backendUnavailable(err) {
+			return v, err
+		}
+		f.degradeLocked(err)
+	}
+	local, err := write(f.mirror)
+	if err != nil {
+		return local, err
+	}
+	f.pending = append(f.pending, func(s Store) error {
+		_, err := write(s)
+		return err
+	})
+	return local, nil
+}
+
+// retry replays the queued writes and, once they are all made, leaves the
+// degraded state. It stops while the backend can't be reached and tries
+// again next time; a write the backend refuses is dropped.
+func (f *fallbackStore) retry() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.Degraded() {
+		return
+	}
+	for len(f.pending) > 0 {
+		if err := f.pending[0](f.backend); backendUnavailable(err) {
+			fmt.Printf("Store still unavailable, %d changes waiting: %v\n", len(f.pending), err)
+			return
+		} else if err != nil {
+			// The backend is up but refused the write; retrying won't help
+			fmt.Printf("Error replaying queued change, dropping it: %v\n", err)
+		}
+		f.pending = f.pending[1:]
+	}
+	// With nothing queued, probe the backend with a cheap read
+	if _, err := f.backend.EventsSince(time.Now()); err != nil {
+		fmt.Printf("Store still unavailable: %v\n", err)
+		return
+	}
+	f.degraded.Store(false)
+	fmt.Println("Store recovered; queued changes written")
+}
+
+// retryLoop calls retry every storeRetryInterval until ctx is done.
+func (f *fallbackStore) retryLoop(ctx context.Context) {
+	ticker := time.NewTicker(storeRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.retry()
+		}
+	}
+}
+
+// retryStore keeps retrying store while it is degraded, until ctx is done.
+// Stores without a fallback return at once.
+func retryStore(ctx context.Context, store Store) {
+	if f, ok := store.(*fallbackStore); ok {
+		f.retryLoop(ctx)
+	}
+}
+
+// StoreDegraded reports whether the tracker's store has fallen back to
+// memory because its backend is unavailable.
+func (t *Tracker) StoreDegraded() bool {
+	f, ok := t.store.(*fallbackStore)
+	return ok && f.Degraded()
+}
+
+// Flush flushes the backend if it supports it. A degraded store can't flush
+// until its queued writes have been made.
+func (f *fallbackStore) Flush() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Degraded() {
+		return "", fmt.Errorf("store unavailable, %d changes waiting to be written", len(f.pending))
+	}
+	if backend, ok := f.backend.(flushingStore); ok {
+		return backend.Flush()
+	}
+	return "", nil
+}
+
+func (f *fallbackStore) Events() ([]CoffeeIntakeEvent, error) {
+	return readFallback(f, Store.Events)
+}
+
+func (f *fallbackStore) EventsSince(since time.Time) ([]CoffeeIntakeEvent, error) {
+	return readFallback(f, func(s Store) ([]CoffeeIntakeEvent, error) {
+		return s.EventsSince(since)
+	})
+}
+
+func (f *fallbackStore) Add(event CoffeeIntakeEvent) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.Add(event)
+	})
+	return err
+}
+
+// removal is the result of Store.Remove.
+type removal struct {
+	event CoffeeIntakeEvent
+	found bool
+}
+
+func (f *fallbackStore) Remove(id string) (CoffeeIntakeEvent, bool, error) {
+	r, err := writeFallback(f, func(s Store) (removal, error) {
+		event, found, err := s.Remove(id)
+		return removal{event, found}, err
+	})
+	return r.event, r.found, err
+}
+
+func (f *fallbackStore) TrimOldest(max int) ([]CoffeeIntakeEvent, error) {
+	return writeFallback(f, func(s Store) ([]CoffeeIntakeEvent, error) {
+		return s.TrimOldest(max)
+	})
+}
+
+func (f *fallbackStore) Tombstones() ([]Tombstone, error) {
+	return readFallback(f, Store.Tombstones)
+}
+
+func (f *fallbackStore) AddTombstone(tombstone Tombstone) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.AddTombstone(tombstone)
+	})
+	return err
+}
+
+func (f *fallbackStore) TrimTombstones(cutoff time.Time, max int) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.TrimTombstones(cutoff, max)
+	})
+	return err
+}
+
+func (f *fallbackStore) Trash() ([]DeletedEvent, error) {
+	return readFallback(f, Store.Trash)
+}
+
+func (f *fallbackStore) AddTrash(deleted DeletedEvent) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.AddTrash(deleted)
+	})
+	return err
+}
+
+// trashRemoval is the result of Store.RemoveTrash.
+type trashRemoval struct {
+	deleted DeletedEvent
+	found   bool
+}
+
+func (f *fallbackStore) RemoveTrash(id string) (DeletedEvent, bool, error) {
+	r, err := writeFallback(f, func(s Store) (trashRemoval, error) {
+		deleted, found, err := s.RemoveTrash(id)
+		return trashRemoval{deleted, found}, err
+	})
+	return r.deleted, r.found, err
+}
+
+func (f *fallbackStore) TrimTrash(cutoff time.Time) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.TrimTrash(cutoff)
+	})
+	return err
+}
+
+func (f *fallbackStore) SleepEntries() ([]SleepEntry, error) {
+	return readFallback(f, Store.SleepEntries)
+}
+
+func (f *fallbackStore) AddSleep(entry SleepEntry) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.AddSleep(entry)
+	})
+	return err
+}
+
+func (f *fallbackStore) ArchiveDay(day time.Time, events []CoffeeIntakeEvent) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.ArchiveDay(day, events)
+	})
+	return err
+}
+
+func (f *fallbackStore) Rollups() ([]DayRollup, error) {
+	return readFallback(f, Store.Rollups)
+}
+
+func (f *fallbackStore) SaveRollup(rollup DayRollup) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.SaveRollup(rollup)
+	})
+	return err
+}
+
+func (f *fallbackStore) DeleteRollup(day time.Time) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.DeleteRollup(day)
+	})
+	return err
+}
+
+func (f *fallbackStore) Scenarios() ([]Scenario, error) {
+	return readFallback(f, Store.Scenarios)
+}
+
+func (f *fallbackStore) SaveScenario(scenario Scenario) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.SaveScenario(scenario)
+	})
+	return err
+}
+
+func (f *fallbackStore) DeleteScenario(name string) (bool, error) {
+	return writeFallback(f, func(s Store) (bool, error) {
+		return s.DeleteScenario(name)
+	})
+}
+
+func (f *fallbackStore) Profiles() ([]string, error) {
+	return readFallback(f, Store.Profiles)
+}
+
+func (f *fallbackStore) AddProfile(name string) error {
+	_, err := writeFallback(f, func(s Store) (struct{}, error) {
+		return struct{}{}, s.AddProfile(name)
+	})
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// flakyStore is a memory store whose writes fail with err while it is set.
+type flakyStore struct {
+	*memoryStore
+	err error
+}
+
+func (s *flakyStore) Add(event CoffeeIntakeEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.memoryStore.Add(event)
+}
+
+func TestFallbackStoreDegradesOnlyWhenUnreachable(t *testing.T) {
+	backend := &flakyStore{memoryStore: newMemoryStore()}
+	f, err := newFallbackStore(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend.err = redisError("OOM command not allowed when used memory > 'maxmemory'")
+	if err := f.Add(CoffeeIntakeEvent{ID: "a", Time: testStart, Amount: 80}); !errors.Is(err, backend.err) {
+		t.Fatalf("Add with a reply error = %v, want it returned", err)
+	}
+	if f.Degraded() || len(f.pending) != 0 || len(f.mirror.events) != 0 {
+		t.Fatalf("reply error degraded the store: degraded %v, %d queued, %d mirrored", f.Degraded(), len(f.pending), len(f.mirror.events))
+	}
+
+	backend.err = io.EOF
+	if err := f.Add(CoffeeIntakeEvent{ID: "b", Time: testStart, Amount: 80}); err != nil {
+		t.Fatalf("Add with the backend down = %v, want it queued", err)
+	}
+	if !f.Degraded() || len(f.pending) != 1 {
+		t.Fatalf("connection error: degraded %v with %d queued, want degraded with 1", f.Degraded(), len(f.pending))
+	}
+
+	// A queued write the backend refuses is dropped rather than blocking recovery
+	backend.err = redisError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	f.retry()
+	if f.Degraded() || len(f.pending) != 0 {
+		t.Errorf("after retry: degraded %v with %d queued, want recovered", f.Degraded(), len(f.pending))
+	}
+}
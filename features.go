@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// optionalFeature is a group of routes that can be turned off with
+// -disable-features, so a deployment only exposes what it uses. Routes
+// that aren't part of a feature are core and always served.
+type optionalFeature struct {
+	name     string
+	register func(s *server, rt *routeTable)
+}
+
+// optionalFeatures lists the features in the order they are registered.
+var optionalFeatures = []optionalFeature{
+	{"import", (*server).registerImport},
+	{"export", (*server).registerExport},
+	{"stream", (*server).registerStream},
+	{"calendar", (*server).registerCalendar},
+}
+
+// parseDisabledFeatures parses a comma-separated list of feature names.
+func parseDisabledFeatures(spec string) (map[string]bool, error) {
+	disabled := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.ContainsFunc(optionalFeatures, func(f optionalFeature) bool { return f.name == name }) {
+			return nil, fmt.Errorf("unknown feature %q, expected one of %s", name, strings.Join(featureNames(), ", "))
+		}
+		disabled[name] = true
+	}
+	return disabled, nil
+}
+
+// featureNames returns the names of the optional features.
+func featureNames() []string {
+	names := make([]string, len(optionalFeatures))
+	for i, f := range optionalFeatures {
+		names[i] = f.name
+	}
+	return names
+}
+
+// registerFeatures registers the routes of every feature not disabled.
+// Routes of disabled features stay unregistered, so the API mux answers
+// 404 for them.
+func (s *server) registerFeatures(rt *routeTable) {
+	for _, f := range optionalFeatures {
+		if s.disabled[f.name] {
+			continue
+		}
+		f.register(s, rt)
+	}
+}
+
+func (s *server) registerImport(rt *routeTable) {
+	rt.handle("/api/import", s.handleImport, http.MethodPost)
+	rt.handle("/api/import/foreign", s.handleImportForeign, http.MethodPost)
+	rt.handle("/api/import/apple-health", s.handleImportAppleHealth, http.MethodPost)
+}
+
+func (s *server) registerExport(rt *routeTable) {
+	rt.handle("/api/export", s.handleExport, http.MethodGet)
+}
+
+func (s *server) registerStream(rt *routeTable) {
+	rt.handle("/api/stream", s.handleStream, http.MethodGet)
+	rt.handle("/api/poll", s.handlePoll, http.MethodGet)
+}
+
+func (s *server) registerCalendar(rt *routeTable) {
+	rt.handle("/api/bedtime.ics", s.handleBedtimeICS, http.MethodGet)
+}
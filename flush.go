@@ -0,0 +1,37 @@
+package main
+
+// flushingStore is implemented by stores that can be told to write their
+// data to durable storage right away.
+type flushingStore interface {
+	// Flush persists everything stored so far and returns once the write
+	// has completed, along with where the data was written, if known.
+	Flush() (path string, err error)
+}
+
+// FlushResult describes a completed flush.
+type FlushResult struct {
+	Persistent bool   `json:"persistent"` // false for the in-memory store, where flushing is a no-op
+	Events     int    `json:"events"`     // Events held by the store when it was flushed
+	Path       string `json:"path,omitempty"`
+}
+
+// Flush makes the store persist its current state, e.g. before taking a
+// backup. Stores without durable storage have nothing to flush. The
+// tracker lock is only held while counting events: a Redis flush can take
+// minutes, and the store serialises its own access.
+func (t *Tracker) Flush() (FlushResult, error) {
+	t.mu.Lock()
+	events, err := t.store.Events()
+	t.mu.Unlock()
+	if err != nil {
+		return FlushResult{}, err
+	}
+	result := FlushResult{Events: len(events)}
+	if store, ok := t.store.(flushingStore); ok {
+		result.Persistent = true
+		if result.Path, err = store.Flush(); err != nil {
+			return FlushResult{}, err
+		}
+	}
+	return result, nil
+}
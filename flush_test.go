@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// slowFlushStore is a memory store whose Flush blocks until released.
+type slowFlushStore struct {
+	*memoryStore
+	started, release chan struct{}
+}
+
+func (s *slowFlushStore) Flush() (string, error) {
+	close(s.started)
+	<-s.release
+	return "dump.rdb", nil
+}
+
+func TestFlushDoesNotBlockTheTracker(t *testing.T) {
+	store := &slowFlushStore{memoryStore: newMemoryStore(), started: make(chan struct{}), release: make(chan struct{})}
+	tracker := NewTrackerWithStore(store, newFakeClock(testStart))
+	mustAdd(t, tracker, testStart, 80)
+
+	done := make(chan FlushResult)
+	go func() {
+		result, err := tracker.Flush()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- result
+	}()
+	<-store.started
+
+	added := make(chan struct{})
+	go func() {
+		if _, _, err := tracker.AddEvent(CoffeeIntakeEvent{Time: testStart.Add(time.Hour), Amount: 60}); err != nil {
+			t.Error(err)
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddEvent blocked while the store was flushing")
+	}
+
+	close(store.release)
+	if result := <-done; !result.Persistent || result.Events != 1 || result.Path != "dump.rdb" {
+		t.Errorf("Flush = %+v, want 1 persistent event in dump.rdb", result)
+	}
+}
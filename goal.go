@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Goal is a personal caffeine target, checked once per stats day. Which
+// fields are used depends on Type.
+type Goal struct {
+	Type    string  `json:"type"`              // A key of goalTypes
+	Cutoff  string  `json:"cutoff,omitempty"`  // "cutoff": no caffeine from this local time, e.g. "14:00"
+	LimitMg float64 `json:"limitMg,omitempty"` // "dailyLimit": most mg per stats day
+}
+
+// GoalProgress is the day's adherence to the goal so far.
+type GoalProgress struct {
+	Goal        Goal       `json:"goal"`
+	DayStart    time.Time  `json:"dayStart"`
+	OnTrack     bool       `json:"onTrack"`
+	Status      string     `json:"status"`                // e.g. "on track" or "violated at 3:10 PM with 95 mg"
+	ViolatedAt  *time.Time `json:"violatedAt,omitempty"`  // Time of the first drink that broke the goal
+	ViolatingMg float64    `json:"violatingMg,omitempty"` // Amount of that drink
+	CountedMg   float64    `json:"countedMg"`             // Intake the goal counts against, so far
+}
+
+// goalType validates and evaluates one kind of goal.
+type goalType struct {
+	validate func(goal Goal) error
+	// evaluate checks the drinks of the stats day starting at dayStart,
+	// in chronological order, and fills in OnTrack, ViolatedAt, ViolatingMg
+	// and CountedMg.
+	evaluate func(goal Goal, day []CoffeeIntakeEvent, dayStart time.Time, loc *time.Location) GoalProgress
+}
+
+// goalTypes holds the known goals by type. To support another kind of goal,
+// add its validation and evaluation here.
+var goalTypes = map[string]goalType{
+	"cutoff":     {validate: validateCutoffGoal, evaluate: evaluateCutoffGoal},
+	"dailyLimit": {validate: validateDailyLimitGoal, evaluate: evaluateDailyLimitGoal},
+}
+
+// validate reports whether the goal is of a known type with usable parameters.
+func (g Goal) validate() error {
+	kind, ok := goalTypes[g.Type]
+	if !ok {
+		return fmt.Errorf("goal type must be \"cutoff\" or \"dailyLimit\", got %q", g.Type)
+	}
+	return kind.validate(g)
+}
+
+func validateCutoffGoal(goal Goal) error {
+	if _, err := time.Parse("15:04", goal.Cutoff); err != nil {
+		return fmt.Errorf("invalid cutoff %q: want HH:MM", goal.Cutoff)
+	}
+	return nil
+}
+
+// evaluateCutoffGoal is broken by the first drink at or after the cutoff.
+// A cutoff earlier in the day than the reset hour falls on the next
+// calendar day, so the stats day is covered in one piece.
+func evaluateCutoffGoal(goal Goal, day []CoffeeIntakeEvent, dayStart time.Time, loc *time.Location) GoalProgress {
+	clock, _ := time.Parse("15:04", goal.Cutoff)
+	local := dayStart.In(loc)
+	cutoff := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	if cutoff.Before(dayStart) {
+		cutoff = cutoff.AddDate(0, 0, 1)
+	}
+
+	progress := GoalProgress{OnTrack: true}
+	for _, event := range day {
+		if event.Time.Before(cutoff) || event.Override {
+			continue
+		}
+		if progress.ViolatedAt == nil {
+			progress.violate(event)
+		}
+		progress.CountedMg += event.Amount
+	}
+	return progress
+}
+
+// violate records event as the drink that broke the goal.
+func (p *GoalProgress) violate(event CoffeeIntakeEvent) {
+	at := event.Time
+	p.OnTrack, p.ViolatedAt, p.ViolatingMg = false, &at, event.Amount
+}
+
+func validateDailyLimitGoal(goal Goal) error {
+	if goal.LimitMg <= 0 {
+		return errors.New("goal limitMg must be positive")
+	}
+	return nil
+}
+
+// evaluateDailyLimitGoal is broken by the drink that takes the day's total
+// over the limit.
+func evaluateDailyLimitGoal(goal Goal, day []CoffeeIntakeEvent, dayStart time.Time, loc *time.Location) GoalProgress {
+	progress := GoalProgress{OnTrack: true}
+	for _, event := range day {
+		if event.Override {
+			continue
+		}
+		progress.CountedMg += event.Amount
+		if progress.ViolatedAt == nil && progress.CountedMg > goal.LimitMg {
+			progress.violate(event)
+		}
+	}
+	return progress
+}
+
+// SetGoal validates and replaces the goal; nil clears it.
+func (t *Tracker) SetGoal(goal *Goal) error {
+	if goal != nil {
+		if err := goal.validate(); err != nil {
+			return err
+		}
+		g := *goal
+		goal = &g
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.config.Goal = goal
+	t.version++
+	t.notifier.Notify()
+	return nil
+}
+
+// GoalProgress evaluates the goal against the drinks logged since the last
+// morning reset. It returns ok=false if no goal is set.
+func (t *Tracker) GoalProgress() (progress GoalProgress, ok bool) {
+	config := t.Config()
+	if config.Goal == nil {
+		return GoalProgress{}, false
+	}
+	loc := config.Location()
+	dayStart := t.DayStart()
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	day := make([]CoffeeIntakeEvent, 0)
+	for _, event := range t.eventsSince(dayStart) {
+		if event.Time.Before(dayEnd) {
+			day = append(day, event)
+		}
+	}
+	progress = goalTypes[config.Goal.Type].evaluate(*config.Goal, day, dayStart, loc)
+	progress.Goal, progress.DayStart = *config.Goal, dayStart
+
+	progress.Status = "on track"
+	if !progress.OnTrack {
+		progress.Status = fmt.Sprintf("violated at %s with %g mg", formatClock(*progress.ViolatedAt, loc), config.Round(progress.ViolatingMg))
+	}
+	return progress, true
+}
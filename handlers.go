@@ -0,0 +1,2151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverOptions holds the command-line settings of the HTTP server.
+type serverOptions struct {
+	AccessLog io.Writer // Destination of access log lines; stdout if nil
+	BasePath  string    // Path prefix of all routes, e.g. "/coffee"; empty to serve at the root
+	Debug     bool      // Whether to serve the /api/debug endpoints
+	CORS      string    // Comma-separated origins allowed to call the API, "*" for any; empty disables CORS
+	ReadOnly  bool      // Whether to reject every request that changes state
+	// DisabledFeatures names the optional features (see features.go) whose
+	// routes are not served.
+	DisabledFeatures map[string]bool
+}
+
+// server wires the HTTP API to the Tracker of each profile.
+type server struct {
+	profiles  *profiles
+	accessLog *slog.Logger
+	basePath  string
+	debug     bool
+	cors      corsOrigins
+	readOnly  bool
+	disabled  map[string]bool // Optional features not served
+}
+
+// newServer creates a server backed by the given profiles.
+func newServer(profiles *profiles, opts serverOptions) *server {
+	if opts.AccessLog == nil {
+		opts.AccessLog = os.Stdout
+	}
+	return &server{
+		profiles:  profiles,
+		accessLog: slog.New(slog.NewTextHandler(opts.AccessLog, nil)),
+		basePath:  opts.BasePath,
+		debug:     opts.Debug,
+		cors:      parseCORSOrigins(opts.CORS),
+		readOnly:  opts.ReadOnly,
+		disabled:  opts.DisabledFeatures,
+	}
+}
+
+// routes registers all endpoints and returns the root handler with middleware applied.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	// Serve static files
+	mux.Handle("/", staticFiles("static"))
+
+	// API endpoints live on their own mux, so a method a route doesn't
+	// answer gets 405 rather than falling through to the static files
+	api := http.NewServeMux()
+	mux.Handle("/api/", api)
+	mux.Handle("/healthz", api)
+	rt := &routeTable{mux: api}
+	rt.handle("/api/add-coffee", s.handleAddCoffee, http.MethodPost)
+	rt.handle("/api/caffeine-level", s.handleCaffeineLevel, http.MethodGet)
+	rt.handle("/api/level.txt", s.handleLevelText, http.MethodGet)
+	rt.handle("/api/active-cups", s.handleActiveCups, http.MethodGet)
+	rt.handle("/api/active", s.handleActive, http.MethodGet)
+	rt.handle("/api/wiredness", s.handleWiredness, http.MethodGet)
+	rt.handle("/api/forecast", s.handleForecast, http.MethodGet)
+	rt.handle("/api/forecast/without", s.handleForecastWithout, http.MethodGet)
+	rt.handle("/api/forecast/markers", s.handleForecastMarkers, http.MethodGet)
+	rt.handle("/api/forecast/breakdown", s.handleForecastBreakdown, http.MethodGet)
+	rt.handle("/api/forecast/card", s.handleForecastCard, http.MethodGet)
+	rt.handle("/api/events", s.handleEvents, http.MethodGet)
+	rt.handle("/api/events/latest", s.handleLatestEvent, http.MethodGet)
+	rt.handle("/api/events/changes", s.handleEventChanges, http.MethodGet)
+	rt.handle("/api/events/{id}", s.handleEvent, http.MethodGet, http.MethodPatch, http.MethodDelete)
+	rt.handle("/api/events/{id}/restore", s.handleRestoreEvent, http.MethodPost)
+	rt.handle("/api/levels", s.handleLevels, http.MethodPost)
+	rt.handle("/api/crash", s.handleCrash, http.MethodGet)
+	rt.handle("/api/summary", s.handleSummary, http.MethodGet)
+	rt.handle("/api/stats/record", s.handleRecordDay, http.MethodGet)
+	rt.handle("/api/stats/weekly-compare", s.handleWeeklyCompare, http.MethodGet)
+	rt.handle("/api/stats/rolling", s.handleRollingStats, http.MethodGet)
+	rt.handle("/api/dashboard", s.handleDashboard, http.MethodGet)
+	rt.handle("/api/metrics/daily", s.handleDailyMetrics, http.MethodGet)
+	rt.handle("/api/config", s.handleConfig, http.MethodGet, http.MethodPatch)
+	rt.handle("/api/config/reset", s.handleResetConfig, http.MethodPost)
+	rt.handle("/api/calibrate", s.handleCalibrate, http.MethodPost)
+	rt.handle("/api/model", s.handleModel, http.MethodGet)
+	rt.handle("/api/curve-params", s.handleCurveParams, http.MethodGet)
+	rt.handle("/api/sleep", s.handleSleep, http.MethodPost)
+	rt.handle("/api/alertness", s.handleAlertness, http.MethodGet)
+	rt.handle("/api/today", s.handleToday, http.MethodGet)
+	rt.handle("/api/budget", s.handleBudget, http.MethodGet)
+	rt.handle("/api/intake-window", s.handleIntakeWindow, http.MethodGet)
+	rt.handle("/api/goal", s.handleGoal, http.MethodGet, http.MethodPut, http.MethodDelete)
+	rt.handle("/api/goal/progress", s.handleGoalProgress, http.MethodGet)
+	rt.handle("/api/version", s.handleVersion, http.MethodGet)
+	rt.handle("/api/profiles", s.handleProfiles, http.MethodGet, http.MethodPost)
+	rt.handle("/api/boost", s.handleBoost, http.MethodPost)
+	rt.handle("/api/alert-check", s.handleAlertCheck, http.MethodGet)
+	rt.handle("/api/snooze", s.handleSnooze, http.MethodGet, http.MethodPost, http.MethodDelete)
+	rt.handle("/api/suggest", s.handleSuggest, http.MethodGet)
+	rt.handle("/api/topup", s.handleTopUp, http.MethodGet)
+	rt.handle("/api/lookup", s.handleLookup, http.MethodGet)
+	rt.handle("/api/override", s.handleOverride, http.MethodPost)
+	rt.handle("/api/maintenance/verify", s.handleVerify, http.MethodGet)
+	rt.handle("/api/flush", s.handleFlush, http.MethodPost)
+	rt.handle("/api/ping", s.handlePing, http.MethodGet)
+	rt.handle("/api/crossings", s.handleCrossings, http.MethodPost)
+	rt.handle("/api/solve", s.handleSolve, http.MethodGet)
+	rt.handle("/api/simulate", s.handleSimulate, http.MethodPost)
+	rt.handle("/api/scenarios", s.handleScenarios, http.MethodGet, http.MethodPost)
+	rt.handle("/api/scenarios/{name}", s.handleScenario, http.MethodGet, http.MethodDelete)
+	rt.handle("/api/scenarios/{name}/forecast", s.handleScenarioForecast, http.MethodGet)
+	rt.handle("/api/coverage", s.handleCoverage, http.MethodGet)
+	rt.handle("/healthz", s.handleHealthz, http.MethodGet)
+	s.registerFeatures(rt)
+
+	// Debug endpoints expose model internals and are off unless -debug is set
+	if s.debug {
+		rt.handle("/api/debug/level", s.handleDebugLevel, http.MethodGet)
+		rt.handle("/api/routes", s.handleRoutes(rt), http.MethodGet)
+	}
+
+	var handler http.Handler = s.requireProfile(mux)
+	if s.readOnly {
+		handler = readOnly(handler)
+	}
+	if s.basePath != "" {
+		handler = underBasePath(s.basePath, handler)
+	}
+	handler = gzipMiddleware(prettyJSON(s.envelopeResponses(handler)))
+	handler = recoverPanics(s.accessLog, corsMiddleware(s.cors, handler))
+	return withRequestID(logRequests(s.accessLog, handler))
+}
+
+func (s *server) handleAddCoffee(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tracker := s.trackerFor(r)
+	// Without a body the user's usual drink is logged, if they have one
+	usual := tracker.Config().DefaultDrink
+	req, ok := decodeBody[DrinkRequest](w, r, usual != nil)
+	if !ok {
+		return
+	}
+
+	if err := validateNote(req.Note); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	event := CoffeeIntakeEvent{Amount: req.Amount, Type: req.Type, Name: req.Name, Tags: req.Tags, Note: req.Note, EmptyStomach: req.EmptyStomach}
+	if event.Amount == 0 && event.Name != "" {
+		// No amount given: look the name up in the drink table
+		if match, _ := tracker.LookupDrink(event.Name); match.Confident {
+			event.Amount = match.Drink.Amount
+			if event.Type == "" {
+				event.Type = match.Drink.Type
+			}
+		} else if usual == nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: amount is required, no known drink matches %q", event.Name), http.StatusBadRequest)
+			return
+		}
+	}
+	if event.Amount == 0 {
+		// No amount given: log the user's usual drink, if they have one
+		if usual == nil {
+			http.Error(w, "Invalid request body: amount is required when no default drink is configured", http.StatusBadRequest)
+			return
+		}
+		event.Amount = usual.Amount
+		if event.Type == "" {
+			event.Type = usual.Type
+		}
+		if event.Name == "" {
+			event.Name = usual.Name
+		}
+	}
+	if math.IsNaN(event.Amount) || math.IsInf(event.Amount, 0) || event.Amount <= 0 {
+		http.Error(w, "Invalid amount: must be a positive number of mg", http.StatusBadRequest)
+		return
+	}
+
+	event, warning, err := tracker.AddEvent(event)
+	if errors.Is(err, errPastLastCall) {
+		http.Error(w, lastCallMessage(tracker.Config()), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error adding drink: %v\n", err)
+		http.Error(w, "Failed to save drink", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, addCoffeeResponse{Status: "success", Event: event, Warning: warning})
+}
+
+// lastCallMessage explains why a drink was rejected in strict last-call mode.
+func lastCallMessage(config Config) string {
+	return fmt.Sprintf("Drink not logged: it is after the last call at %02d:00 (lastCallStrict is set)", config.LastCallHour)
+}
+
+// addCoffeeResponse confirms what was logged
+type addCoffeeResponse struct {
+	Status  string            `json:"status"`
+	Event   CoffeeIntakeEvent `json:"event"`
+	Warning string            `json:"warning,omitempty"` // Advisory such as a too-short interval or a late drink; the drink is logged regardless
+}
+
+func (s *server) handleCaffeineLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	now := tracker.Now()
+	w.Header().Add("Vary", "Accept")
+	if notModified(w, r, timedETag(tracker.Version(), now)) {
+		return
+	}
+	level, clamped := tracker.PlausibleLevelAt(now)
+	config := tracker.Config()
+	if v := r.URL.Query().Get("halfLife"); v != "" {
+		// What-if: the level under another half-life, leaving the settings alone
+		halfLife, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(halfLife) || math.IsInf(halfLife, 0) || halfLife <= 0 {
+			http.Error(w, "Invalid halfLife: must be a positive number of hours", http.StatusBadRequest)
+			return
+		}
+		level, clamped = config.Clamp(tracker.CalculateCaffeineLevelWith(now, halfLife))
+	}
+	setUnitHeader(w, config)
+	if wantsText(r) {
+		loc, err := requestLocation(r, config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeText(w, http.StatusOK, fmt.Sprintf("%g %s at %s", config.Display(level), config.DisplayUnit, formatClock(now, loc)))
+		return
+	}
+	writeJSON(w, http.StatusOK, levelResponse{
+		Level:      config.Display(level),
+		Unit:       config.DisplayUnit,
+		Clamped:    clamped,
+		Thresholds: config.DisplayThresholds(),
+	})
+}
+
+// handleLevelText serves the current level as nothing but a whole number
+// of mg, e.g. "142", for devices that can only show a fetched string. It
+// ignores the display unit and content negotiation.
+func (s *server) handleLevelText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	level, _ := tracker.PlausibleLevelAt(tracker.Now())
+	body := strconv.FormatFloat(math.Round(level), 'f', 0, 64)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	io.WriteString(w, body)
+}
+
+// levelResponse is the current caffeine level in the display unit
+type levelResponse struct {
+	Level      float64    `json:"level"`
+	Unit       string     `json:"unit"`
+	Clamped    bool       `json:"clamped,omitempty"` // Level was capped at maxPlausibleMg
+	Thresholds Thresholds `json:"thresholds"`
+}
+
+// activeCupsResponse is the current caffeine level as cups of coffee
+type activeCupsResponse struct {
+	Cups float64 `json:"cups"`
+	Mg   float64 `json:"mg"`
+}
+
+func (s *server) handleActiveCups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	level := tracker.CalculateCaffeineLevelAt(tracker.Now())
+	config := tracker.Config()
+	writeJSON(w, http.StatusOK, activeCupsResponse{
+		Cups: config.Round(level / caffeineUnits["cup"]),
+		Mg:   config.Round(level),
+	})
+}
+
+// wirednessResponse is the current level on a 0-1 scale, with the raw level
+type wirednessResponse struct {
+	Wiredness float64 `json:"wiredness"`
+	Level     float64 `json:"level"`
+	Unit      string  `json:"unit"`
+}
+
+func (s *server) handleWiredness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	level := tracker.CalculateCaffeineLevelAt(tracker.Now())
+	config := tracker.Config()
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, wirednessResponse{
+		// Two decimals regardless of roundTo, which is meant for mg
+		Wiredness: math.Round(config.Wiredness(level)*100) / 100,
+		Level:     config.Display(level),
+		Unit:      config.DisplayUnit,
+	})
+}
+
+// activeMinMg is the default contribution an event must exceed to count as
+// still active.
+const activeMinMg = 1.0
+
+// handleActive lists the drinks still in the system, largest remaining
+// amount first. ?min= overrides activeMinMg.
+func (s *server) handleActive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	minMg := activeMinMg
+	if v := r.URL.Query().Get("min"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed < 0 {
+			http.Error(w, "Invalid min: must be a non-negative number of mg", http.StatusBadRequest)
+			return
+		}
+		minMg = parsed
+	}
+	if notModified(w, r, timedETag(tracker.Version(), tracker.Now())) {
+		return
+	}
+	active := tracker.ActiveContributions(tracker.Now(), minMg)
+	config := tracker.Config()
+	for i := range active {
+		active[i].ElapsedHours = config.Round(active[i].ElapsedHours)
+		active[i].RemainingMg = config.Round(active[i].RemainingMg)
+	}
+	writeJSON(w, http.StatusOK, active)
+}
+
+func (s *server) handleForecast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	if notModified(w, r, timedETag(tracker.Version(), tracker.Now())) {
+		return
+	}
+	query := r.URL.Query()
+	forecast := tracker.GenerateForecast()
+	if query.Get("smooth") == "true" {
+		forecast = smoothForecast(forecast)
+	}
+	markers := query.Get("markers") == "true"
+	if markers {
+		forecast = tracker.WithScheduleMarkers(forecast)
+	}
+	forecast = displayForecast(w, tracker.Config(), forecast)
+	switch format := query.Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, forecast)
+	case "columnar":
+		if markers {
+			http.Error(w, "markers are only supported in the JSON format", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, columnar(forecast))
+	default:
+		http.Error(w, fmt.Sprintf("Unknown forecast format %q", format), http.StatusBadRequest)
+	}
+}
+
+func (s *server) handleForecastWithout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+	tracker := s.trackerFor(r)
+	forecast, ok := tracker.ForecastWithout(id)
+	if !ok {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, displayForecast(w, tracker.Config(), forecast))
+}
+
+func (s *server) handleForecastMarkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	if notModified(w, r, timedETag(tracker.Version(), tracker.Now())) {
+		return
+	}
+	markers := drinkMarkers(tracker.GenerateForecast())
+	writeJSON(w, http.StatusOK, displayForecast(w, tracker.Config(), markers))
+}
+
+func (s *server) handleForecastBreakdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	if notModified(w, r, timedETag(tracker.Version(), tracker.Now())) {
+		return
+	}
+	points := tracker.ForecastBreakdown()
+	config := tracker.Config()
+	for i := range points {
+		for id, level := range points[i].Contributions {
+			points[i].Contributions[id] = config.Display(level)
+		}
+		points[i].Other = config.Display(points[i].Other)
+	}
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, points)
+}
+
+func (s *server) handleForecastCard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	if notModified(w, r, timedETag(tracker.Version(), tracker.Now())) {
+		return
+	}
+	card := tracker.ForecastCard()
+	config := tracker.Config()
+	card.CurrentLevel = config.Display(card.CurrentLevel)
+	card.Peak.Caffeine = config.Display(card.Peak.Caffeine)
+	card.TodayTotal = config.Display(card.TodayTotal)
+	for i := range card.Sparkline {
+		card.Sparkline[i] = config.Display(card.Sparkline[i])
+	}
+	card.Unit = config.DisplayUnit
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, card)
+}
+
+// displayForecast caps the caffeine values of a forecast at the plausible
+// maximum, converts them to the display unit for output and announces the
+// unit in a header.
+func displayForecast(w http.ResponseWriter, config Config, forecast []ForecastPoint) []ForecastPoint {
+	setUnitHeader(w, config)
+	for i := range forecast {
+		forecast[i].Caffeine, forecast[i].Clamped = config.Clamp(forecast[i].Caffeine)
+		forecast[i].Caffeine = config.Display(forecast[i].Caffeine)
+		forecast[i].DrinkAmount = config.Display(forecast[i].DrinkAmount)
+	}
+	return forecast
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	// Derived from the store, so servers sharing it agree on the ETag; the
+	// timezone decides isFirstOfDay
+	count, modified := tracker.EventsState()
+	etag := fmt.Sprintf(`W/"e%d-m%d-%s"`, count, modified.UnixNano(), tracker.Config().Location())
+	if notModified(w, r, etag) {
+		return
+	}
+	var events []CoffeeIntakeEvent
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		events = tracker.EventsByTag(tag)
+	} else {
+		events = tracker.GetEvents()
+	}
+	first := tracker.FirstOfDayIDs()
+	resp := make([]eventResponse, 0, len(events))
+	for _, event := range events {
+		resp = append(resp, eventResponse{CoffeeIntakeEvent: event, IsFirstOfDay: first[event.ID]})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// eventResponse is a stored event with whether it was the first drink of its
+// local calendar day, e.g. to badge the morning coffee
+type eventResponse struct {
+	CoffeeIntakeEvent
+	IsFirstOfDay bool `json:"isFirstOfDay"`
+}
+
+// eventPatch is the body of PATCH /api/events/{id}. Only these fields can
+// be edited; nil fields keep their current values.
+type eventPatch struct {
+	Amount *float64   `json:"amount"`
+	Time   *time.Time `json:"time"`
+	Type   *string    `json:"type"`
+	Note   *string    `json:"note"`
+}
+
+func (s *server) handleLatestEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	event, ok := tracker.LatestEvent()
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, eventResponse{CoffeeIntakeEvent: event, IsFirstOfDay: tracker.IsFirstOfDay(event)})
+}
+
+func (s *server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	tracker := s.trackerFor(r)
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		event, ok := tracker.Event(id)
+		if !ok {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, eventResponse{CoffeeIntakeEvent: event, IsFirstOfDay: tracker.IsFirstOfDay(event)})
+	case http.MethodPatch:
+		// Fields missing from the body keep their current values
+		event, ok := tracker.Event(id)
+		if !ok {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		var patch eventPatch
+		if !decodeInto(w, r, &patch, maxRequestBytes, false) {
+			return
+		}
+		if patch.Amount != nil {
+			event.Amount = *patch.Amount
+		}
+		if patch.Time != nil {
+			event.Time = *patch.Time
+		}
+		if patch.Type != nil {
+			event.Type = *patch.Type
+		}
+		if patch.Note != nil {
+			event.Note = *patch.Note
+		}
+		// Overrides may be negative, like the ones Override stores
+		if math.IsNaN(event.Amount) || math.IsInf(event.Amount, 0) || (!event.Override && event.Amount <= 0) {
+			http.Error(w, "Invalid amount: must be a positive number of mg", http.StatusBadRequest)
+			return
+		}
+		if event.Time.IsZero() {
+			http.Error(w, "Invalid time: must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		if err := validateNote(event.Note); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		event, err := tracker.UpdateEvent(event)
+		if errors.Is(err, errEventNotFound) {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error updating drink: %v\n", err)
+			http.Error(w, "Failed to save drink", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, event)
+	case http.MethodDelete:
+		err := tracker.DeleteEvent(id)
+		if errors.Is(err, errEventNotFound) {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error deleting drink: %v\n", err)
+			http.Error(w, "Failed to delete drink", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRestoreEvent brings back a drink deleted within the restore window.
+func (s *server) handleRestoreEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	event, err := s.trackerFor(r).RestoreEvent(r.PathValue("id"))
+	if errors.Is(err, errNotRestorable) {
+		http.Error(w, "Deleted drink not found or past the restore window", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error restoring drink: %v\n", err)
+		http.Error(w, "Failed to restore drink", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, event)
+}
+
+func (s *server) handleEventChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "Invalid since: must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	changes, err := s.trackerFor(r).ChangesSince(since)
+	if err != nil {
+		fmt.Printf("Error reading changes: %v\n", err)
+		http.Error(w, "Failed to read changes", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+func (s *server) handleLevels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tracker := s.trackerFor(r)
+	// Bound the body too, so an oversized array is rejected before it is fully decoded
+	var times []time.Time
+	if !decodeInto(w, r, &times, maxLevelPoints*64, false) {
+		return
+	}
+	if len(times) > maxLevelPoints {
+		http.Error(w, fmt.Sprintf("Too many timestamps: at most %d allowed", maxLevelPoints), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	levels := tracker.LevelsAt(times)
+	config := tracker.Config()
+	setUnitHeader(w, config)
+	for i := range levels {
+		levels[i].Caffeine = config.Display(levels[i].Caffeine)
+	}
+	writeJSON(w, http.StatusOK, levels)
+}
+
+// crashResponse reports the steepest predicted drop in caffeine level
+type crashResponse struct {
+	Crash     bool       `json:"crash"`
+	Snoozed   bool       `json:"snoozed,omitempty"` // Warnings are snoozed; Message says until when
+	Message   string     `json:"message"`
+	Time      *time.Time `json:"time,omitempty"`
+	Rate      float64    `json:"rate,omitempty"` // Display unit per hour
+	Threshold float64    `json:"threshold"`      // mg per hour
+}
+
+func (s *server) handleCrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tracker := s.trackerFor(r)
+	threshold := defaultCrashThreshold
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed <= 0 {
+			http.Error(w, "Invalid threshold: must be a positive number of mg per hour", http.StatusBadRequest)
+			return
+		}
+		threshold = parsed
+	}
+	config := tracker.Config()
+	loc, err := requestLocation(r, config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := crashResponse{Message: "no crash predicted", Threshold: threshold}
+	at, rate, ok := tracker.SteepestDrop(tracker.Now(), crashHorizon)
+	if ok {
+		resp.Time = &at
+		setUnitHeader(w, config)
+		resp.Rate = config.Display(rate)
+		resp.Crash = rate >= threshold
+		if resp.Crash {
+			resp.Message = fmt.Sprintf("caffeine crash predicted at %s", formatClock(at, loc))
+		}
+	}
+	if until, snoozed := tracker.SnoozedUntil(); snoozed && resp.Crash {
+		resp.Snoozed = true
+		resp.Message = fmt.Sprintf("warnings snoozed until %s", formatClock(until, loc))
+	}
+	if wantsText(r) {
+		writeText(w, http.StatusOK, resp.Message)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	summary := tracker.Summary(tracker.Now(), config.Location())
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, displaySummary(summary, config))
+}
+
+func (s *server) handleRecordDay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	loc, err := requestLocation(r, config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	record, ok := tracker.RecordDay(loc)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, displayRecordDay(record, config))
+}
+
+func (s *server) handleWeeklyCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	loc, err := requestLocation(r, config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, displayWeeklyComparison(tracker.WeeklyComparison(loc), config))
+}
+
+// handleRollingStats totals the last ?days= stats days (default 30).
+func (s *server) handleRollingStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	days := defaultRollingDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRollingDays {
+			http.Error(w, fmt.Sprintf("Invalid days: must be a whole number from 1 to %d", maxRollingDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	loc, err := requestLocation(r, config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := tracker.Now()
+	if notModified(w, r, timedETag(tracker.Version(), now)) {
+		return
+	}
+	stats := tracker.RollingStats(days, now, loc)
+	stats.TotalMg = config.Display(stats.TotalMg)
+	stats.AverageMgPerDay = config.Display(stats.AverageMgPerDay)
+	stats.AverageDrinksPerDay = config.Round(stats.AverageDrinksPerDay)
+	for i := range stats.Daily {
+		stats.Daily[i].TotalMg = config.Display(stats.Daily[i].TotalMg)
+	}
+	stats.Unit = config.DisplayUnit
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleDashboard serves every stats section in one response, computed
+// from one snapshot so the numbers agree.
+func (s *server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	loc, err := requestLocation(r, config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if notModified(w, r, timedETag(tracker.Version(), tracker.Now())) {
+		return
+	}
+	dashboard := tracker.Dashboard(loc)
+	dashboard.Today = displayDayStats(dashboard.Today, config)
+	dashboard.Budget = displayBudget(dashboard.Budget, config)
+	dashboard.Summary = displaySummary(dashboard.Summary, config)
+	if dashboard.Record != nil {
+		record := displayRecordDay(*dashboard.Record, config)
+		dashboard.Record = &record
+	}
+	dashboard.Weekly = displayWeeklyComparison(dashboard.Weekly, config)
+	for i := range dashboard.Daily {
+		dashboard.Daily[i].TotalMg = config.Display(dashboard.Daily[i].TotalMg)
+	}
+	dashboard.Unit = config.DisplayUnit
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, dashboard)
+}
+
+// displaySummary converts the amounts of summary to the display unit and
+// rounds them for output.
+func displaySummary(summary Summary, config Config) Summary {
+	summary.TotalMg = config.Display(summary.TotalMg)
+	summary.AverageDrinksPerDay = config.Round(summary.AverageDrinksPerDay)
+	summary.Unit = config.DisplayUnit
+	return summary
+}
+
+// displayRecordDay converts the amounts of record to the display unit.
+func displayRecordDay(record RecordDay, config Config) RecordDay {
+	record.TotalMg = config.Display(record.TotalMg)
+	record.ExposureMgHours = config.Display(record.ExposureMgHours)
+	record.Unit = config.DisplayUnit
+	return record
+}
+
+// displayWeeklyComparison converts the totals of cmp to the display unit
+// and rounds the changes.
+func displayWeeklyComparison(cmp WeeklyComparison, config Config) WeeklyComparison {
+	for _, totals := range []*WeekTotals{&cmp.Current, &cmp.Previous, &cmp.PreviousFullWeek} {
+		totals.TotalMg = config.Display(totals.TotalMg)
+	}
+	for _, pct := range []*float64{cmp.DrinksChangePct, cmp.MgChangePct} {
+		if pct != nil {
+			*pct = config.Round(*pct)
+		}
+	}
+	cmp.Unit = config.DisplayUnit
+	return cmp
+}
+
+// displayDayStats converts the total of stats to the display unit.
+func displayDayStats(stats DayStats, config Config) DayStats {
+	stats.TotalMg = config.Display(stats.TotalMg)
+	stats.Unit = config.DisplayUnit
+	return stats
+}
+
+// displayBudget converts the amounts of budget to the display unit.
+func displayBudget(budget Budget, config Config) Budget {
+	for _, v := range []*float64{&budget.LimitMg, &budget.GraceMg, &budget.ConsumedMg, &budget.CountedMg, &budget.RemainingMg} {
+		*v = config.Display(*v)
+	}
+	budget.Unit = config.DisplayUnit
+	return budget
+}
+
+// handleDailyMetrics exports the totals of every finished day as
+// OpenMetrics, for backfilling a time-series database.
+func (s *server) handleDailyMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	days := tracker.FinishedDays(tracker.Config().Location())
+	w.Header().Set("Content-Type", openMetricsContentType)
+	if err := WriteDailyMetrics(w, days); err != nil {
+		fmt.Printf("Error writing daily metrics: %v\n", err)
+	}
+}
+
+func (s *server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	tracker := s.trackerFor(r)
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, tracker.Config())
+	case http.MethodPatch:
+		// Fields missing from the body keep their current values
+		config := tracker.Config()
+		if !decodeInto(w, r, &config, maxRequestBytes, false) {
+			return
+		}
+		if err := tracker.SetConfig(config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, config)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) handleResetConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.trackerFor(r).ResetConfig())
+}
+
+// handleModel describes the caffeine model in use. Its parameters are
+// settings, so they are reported unrounded.
+func (s *server) handleModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.trackerFor(r).Config().Model())
+}
+
+// handleCurveParams serves the inputs for computing the level curve on the
+// client.
+func (s *server) handleCurveParams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	if notModified(w, r, timedETag(tracker.Version(), tracker.Now())) {
+		return
+	}
+	writeJSON(w, http.StatusOK, tracker.CurveParams())
+}
+
+func (s *server) handleCalibrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var measurements []Measurement
+	if !decodeInto(w, r, &measurements, maxMeasurements*128, false) {
+		return
+	}
+	if len(measurements) > maxMeasurements {
+		http.Error(w, fmt.Sprintf("Too many measurements: at most %d allowed", maxMeasurements), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	tracker := s.trackerFor(r)
+	calibration, err := tracker.Calibrate(measurements)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	config := tracker.Config()
+	calibration.HalfLifeHours = config.Round(calibration.HalfLifeHours)
+	calibration.ResidualMg = config.Round(calibration.ResidualMg)
+	writeJSON(w, http.StatusOK, calibration)
+}
+
+func (s *server) handleSleep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, ok := decodeJSON[SleepRequest](w, r)
+	if !ok {
+		return
+	}
+	if req.Hours <= 0 || req.Hours > 24 {
+		http.Error(w, "hours must be between 0 and 24", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.trackerFor(r).AddSleep(req.Hours); err != nil {
+		fmt.Printf("Error logging sleep: %v\n", err)
+		http.Error(w, "Failed to save sleep", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+func (s *server) handleAlertness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tracker := s.trackerFor(r)
+	alertness, err := tracker.Alertness(tracker.Now())
+	if err != nil {
+		fmt.Printf("Error estimating alertness: %v\n", err)
+		http.Error(w, "Failed to read sleep history", http.StatusInternalServerError)
+		return
+	}
+	config := tracker.Config()
+	alertness.Score = config.Round(alertness.Score)
+	alertness.CaffeineMg = config.Display(alertness.CaffeineMg)
+	setUnitHeader(w, config)
+	if alertness.HoursAwake != nil {
+		awake := config.Round(*alertness.HoursAwake)
+		alertness.HoursAwake = &awake
+	}
+	writeJSON(w, http.StatusOK, alertness)
+}
+
+func (s *server) handleToday(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	today := displayDayStats(tracker.Today(), config)
+	setUnitHeader(w, config)
+	if wantsText(r) {
+		loc, err := requestLocation(r, config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeText(w, http.StatusOK, fmt.Sprintf("%d drinks, %g %s since %s", today.Drinks, today.TotalMg, today.Unit, formatClock(today.Start, loc)))
+		return
+	}
+	writeJSON(w, http.StatusOK, today)
+}
+
+func (s *server) handleBudget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, displayBudget(tracker.Budget(), config))
+}
+
+// maxIntakeWindowMinutes is the longest window /api/intake-window accepts.
+const maxIntakeWindowMinutes = 24 * 60
+
+// intakeWindowResponse is the amount taken in a rolling window
+type intakeWindowResponse struct {
+	WindowMinutes int     `json:"windowMinutes"`
+	Total         float64 `json:"total"`
+	Limit         float64 `json:"limit"` // intakeWindowLimitMg, in the response unit; 0 if disabled
+	OverLimit     bool    `json:"overLimit"`
+	Unit          string  `json:"unit"`
+}
+
+func (s *server) handleIntakeWindow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	minutes := config.IntakeWindowMinutes
+	if v := r.URL.Query().Get("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxIntakeWindowMinutes {
+			http.Error(w, fmt.Sprintf("Invalid minutes: must be a whole number between 1 and %d", maxIntakeWindowMinutes), http.StatusBadRequest)
+			return
+		}
+		minutes = n
+	}
+
+	total := tracker.IntakeInWindow(time.Duration(minutes) * time.Minute)
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, intakeWindowResponse{
+		WindowMinutes: minutes,
+		Total:         config.Display(total),
+		Limit:         config.Display(config.IntakeWindowLimitMg),
+		OverLimit:     config.IntakeWindowLimitMg > 0 && total > config.IntakeWindowLimitMg,
+		Unit:          config.DisplayUnit,
+	})
+}
+
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, VersionInfo{Version: version, Commit: commit, BuildTime: buildTime})
+}
+
+// boostResponse is the result of logging a drink with /api/boost
+type boostResponse struct {
+	Event        CoffeeIntakeEvent `json:"event"`
+	BedtimeClear *time.Time        `json:"bedtimeClear"` // nil if not within the projection horizon
+	Peak         LevelPoint        `json:"peak"`
+	ThresholdMg  float64           `json:"thresholdMg"`
+	Warning      string            `json:"warning,omitempty"` // As in addCoffeeResponse
+}
+
+func (s *server) handleBoost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	amount, err := strconv.ParseFloat(r.URL.Query().Get("amount"), 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) || amount <= 0 {
+		http.Error(w, "Invalid amount: must be a positive number of mg", http.StatusBadRequest)
+		return
+	}
+
+	event, warning, err := tracker.AddEvent(CoffeeIntakeEvent{Amount: amount})
+	if errors.Is(err, errPastLastCall) {
+		http.Error(w, lastCallMessage(tracker.Config()), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error adding drink: %v\n", err)
+		http.Error(w, "Failed to save drink", http.StatusInternalServerError)
+		return
+	}
+
+	config := tracker.Config()
+	resp := boostResponse{
+		Event:       event,
+		Peak:        tracker.Peak(event.Time, crashHorizon),
+		ThresholdMg: config.SleepThresholdMg,
+		Warning:     warning,
+	}
+	resp.Peak.Caffeine = config.Display(resp.Peak.Caffeine)
+	setUnitHeader(w, config)
+	if clear, ok := tracker.SafeToSleepAt(); ok {
+		resp.BedtimeClear = &clear
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleImportForeign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	mapper, ok := importMappers[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown import format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	var records []json.RawMessage
+	if !decodeInto(w, r, &records, maxImportBytes, false) {
+		return
+	}
+
+	events, skipped := mapRecords(records, mapper)
+	imported, err := s.trackerFor(r).ImportEvents(events)
+	if err != nil {
+		fmt.Printf("Error importing drinks: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to save drinks after importing %d", imported), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ImportResult{Imported: imported, Skipped: skipped})
+}
+
+// alertCheckResponse reports whether the level is above the alertness floor
+type alertCheckResponse struct {
+	Alert      bool       `json:"alert"`
+	Level      float64    `json:"level"` // Display unit
+	FloorMg    float64    `json:"floorMg"`
+	AlertAt    *time.Time `json:"alertAt"` // nil if the level won't reach the floor
+	ETAMinutes *float64   `json:"etaMinutes,omitempty"`
+}
+
+func (s *server) handleAlertCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	floor := config.AlertFloorMg
+	if v := r.URL.Query().Get("min"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed < 0 {
+			http.Error(w, "Invalid min: must be a non-negative number of mg", http.StatusBadRequest)
+			return
+		}
+		floor = parsed
+	}
+
+	now := tracker.Now()
+	level := tracker.CalculateCaffeineLevelAt(now)
+	resp := alertCheckResponse{
+		Alert:   level >= floor,
+		Level:   config.Display(level),
+		FloorMg: floor,
+	}
+	if at, ok := tracker.NextTimeAtOrAbove(floor); ok {
+		eta := config.Round(at.Sub(now).Minutes())
+		resp.AlertAt, resp.ETAMinutes = &at, &eta
+	}
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleTopUp returns how much to drink now to reach ?target= mg.
+func (s *server) handleTopUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target, err := strconv.ParseFloat(r.URL.Query().Get("target"), 64)
+	if err != nil || math.IsNaN(target) || math.IsInf(target, 0) || target < 0 {
+		http.Error(w, "Invalid target: must be a non-negative number of mg", http.StatusBadRequest)
+		return
+	}
+	tracker := s.trackerFor(r)
+	topUp := tracker.TopUpTo(target)
+	config := tracker.Config()
+	for _, v := range []*float64{&topUp.CurrentMg, &topUp.AmountMg, &topUp.ProjectedMg} {
+		*v = config.Round(*v)
+	}
+	writeJSON(w, http.StatusOK, topUp)
+}
+
+// handleLookup returns the known drink that best matches ?name=.
+func (s *server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if strings.TrimSpace(name) == "" {
+		http.Error(w, "Missing name parameter, e.g. name=grande latte", http.StatusBadRequest)
+		return
+	}
+	tracker := s.trackerFor(r)
+	match, ok := tracker.LookupDrink(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No known drink matches %q", name), http.StatusNotFound)
+		return
+	}
+	config := tracker.Config()
+	match.Drink.Amount = config.Display(match.Drink.Amount)
+	match.Score = math.Round(match.Score*100) / 100
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, match)
+}
+
+// handleOverride sets the current level to ?level= mg by storing a
+// calibration event.
+func (s *server) handleOverride(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	level, err := strconv.ParseFloat(r.URL.Query().Get("level"), 64)
+	if err != nil || math.IsNaN(level) || math.IsInf(level, 0) || level < 0 {
+		http.Error(w, "Invalid level: must be a non-negative number of mg", http.StatusBadRequest)
+		return
+	}
+	event, err := s.trackerFor(r).Override(level)
+	if err != nil {
+		fmt.Printf("Error overriding level: %v\n", err)
+		http.Error(w, "Failed to save override", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, addCoffeeResponse{Status: "success", Event: event})
+}
+
+func (s *server) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	query := r.URL.Query()
+
+	floor := config.AlertFloorMg
+	if v := query.Get("floor"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed < 0 {
+			http.Error(w, "Invalid floor: must be a non-negative number of mg", http.StatusBadRequest)
+			return
+		}
+		floor = parsed
+	}
+	if query.Get("bedtime") == "" {
+		http.Error(w, "Missing bedtime parameter, e.g. bedtime=23:00", http.StatusBadRequest)
+		return
+	}
+	loc, err := requestLocation(r, config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := tracker.Now()
+	bedtime, err := nextClockTime(query.Get("bedtime"), now, loc)
+	if err != nil {
+		http.Error(w, "Invalid bedtime: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Without an explicit until, stay alert right up to bedtime
+	until := bedtime
+	if v := query.Get("until"); v != "" {
+		until, err = nextClockTime(v, now, loc)
+		if err != nil {
+			http.Error(w, "Invalid until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if until.After(bedtime) {
+			http.Error(w, "Invalid until: must not be after bedtime", http.StatusBadRequest)
+			return
+		}
+	}
+
+	suggestion := tracker.SuggestDrink(floor, until, bedtime)
+	suggestion.CoveredPct = config.Round(suggestion.CoveredPct)
+	suggestion.BedtimeLevel = config.Display(suggestion.BedtimeLevel)
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, suggestion)
+}
+
+// verifyResponse is the result of checking the stored events
+type verifyResponse struct {
+	OK      bool    `json:"ok"` // No errors; warnings may remain
+	Checked int     `json:"checked"`
+	Issues  []Issue `json:"issues"`
+}
+
+func (s *server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	issues, checked, err := s.trackerFor(r).Verify()
+	if err != nil {
+		fmt.Printf("Error verifying events: %v\n", err)
+		http.Error(w, "Failed to read events", http.StatusInternalServerError)
+		return
+	}
+	resp := verifyResponse{OK: true, Checked: checked, Issues: issues}
+	for _, issue := range issues {
+		if issue.Severity == "error" {
+			resp.OK = false
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleFlush persists the store before the caller takes a backup.
+func (s *server) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := s.trackerFor(r).Flush()
+	if err != nil {
+		fmt.Printf("Error flushing store: %v\n", err)
+		http.Error(w, "Failed to flush store", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// snoozeResponse reports until when warnings are snoozed
+type snoozeResponse struct {
+	SnoozedUntil *time.Time `json:"snoozedUntil"` // nil if not snoozed
+}
+
+func (s *server) handleSnooze(w http.ResponseWriter, r *http.Request) {
+	tracker := s.trackerFor(r)
+	switch r.Method {
+	case http.MethodGet:
+		var resp snoozeResponse
+		if until, ok := tracker.SnoozedUntil(); ok {
+			resp.SnoozedUntil = &until
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case http.MethodPost:
+		minutes, err := strconv.Atoi(r.URL.Query().Get("minutes"))
+		if err != nil || minutes <= 0 || time.Duration(minutes)*time.Minute > maxSnooze {
+			http.Error(w, fmt.Sprintf("Invalid minutes: must be between 1 and %d", int(maxSnooze.Minutes())), http.StatusBadRequest)
+			return
+		}
+		until := tracker.Snooze(time.Duration(minutes) * time.Minute)
+		writeJSON(w, http.StatusOK, snoozeResponse{SnoozedUntil: &until})
+	case http.MethodDelete:
+		tracker.ClearSnooze()
+		writeJSON(w, http.StatusOK, snoozeResponse{})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// goalResponse holds the current goal, nil if none is set
+type goalResponse struct {
+	Goal *Goal `json:"goal"`
+}
+
+func (s *server) handleGoal(w http.ResponseWriter, r *http.Request) {
+	tracker := s.trackerFor(r)
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, goalResponse{Goal: tracker.Config().Goal})
+	case http.MethodPut:
+		goal, ok := decodeJSON[Goal](w, r)
+		if !ok {
+			return
+		}
+		if err := tracker.SetGoal(&goal); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, goalResponse{Goal: &goal})
+	case http.MethodDelete:
+		tracker.SetGoal(nil)
+		writeJSON(w, http.StatusOK, goalResponse{})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) handleGoalProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	progress, ok := tracker.GoalProgress()
+	if !ok {
+		http.Error(w, "No goal set", http.StatusNotFound)
+		return
+	}
+	config := tracker.Config()
+	progress.ViolatingMg = config.Round(progress.ViolatingMg)
+	progress.CountedMg = config.Round(progress.CountedMg)
+	writeJSON(w, http.StatusOK, progress)
+}
+
+// profilesResponse lists the available profiles
+type profilesResponse struct {
+	Profiles []string `json:"profiles"`
+}
+
+// createProfileRequest names a new profile
+type createProfileRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *server) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, profilesResponse{Profiles: s.profiles.names()})
+	case http.MethodPost:
+		req, ok := decodeJSON[createProfileRequest](w, r)
+		if !ok {
+			return
+		}
+		if err := validateProfileName(req.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.profiles.create(req.Name); errors.Is(err, errProfileExists) {
+			http.Error(w, fmt.Sprintf("Profile %q already exists", req.Name), http.StatusConflict)
+			return
+		} else if err != nil {
+			fmt.Printf("Error creating profile: %v\n", err)
+			http.Error(w, "Failed to create profile", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, profilesResponse{Profiles: s.profiles.names()})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// A copy of the events, so no lock is held while writing to the client
+	events := s.trackerFor(r).GetEvents()
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="caffeine-events.json"`)
+		writeJSON(w, http.StatusOK, events)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="caffeine-events.csv"`)
+		if err := WriteCSV(w, events); err != nil {
+			fmt.Printf("Error writing CSV export: %v\n", err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown export format %q", format), http.StatusBadRequest)
+	}
+}
+
+// handleImport appends events from a file produced by /api/export: CSV when
+// the Content-Type is text/csv, otherwise a JSON array of events. Imported
+// events get new IDs.
+func (s *server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var events []CoffeeIntakeEvent
+	var skipped []SkippedRecord
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		var err error
+		events, skipped, err = ReadCSV(http.MaxBytesReader(w, r.Body, maxImportBytes))
+		if err != nil {
+			http.Error(w, "Invalid CSV: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		var records []json.RawMessage
+		if !decodeInto(w, r, &records, maxImportBytes, false) {
+			return
+		}
+		events, skipped = mapRecords(records, mapNativeRecord)
+	}
+
+	imported, err := s.trackerFor(r).ImportEvents(events)
+	if err != nil {
+		fmt.Printf("Error importing drinks: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to save drinks after importing %d", imported), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ImportResult{Imported: imported, Skipped: skipped})
+}
+
+// handleImportAppleHealth appends the caffeine records of an Apple Health
+// export.xml sent as the request body.
+func (s *server) handleImportAppleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAppleHealthBytes)
+	events, skipped, err := ReadAppleHealth(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid Apple Health export: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imported, err := s.trackerFor(r).ImportEvents(events)
+	if err != nil {
+		fmt.Printf("Error importing drinks: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to save drinks after importing %d", imported), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ImportResult{Imported: imported, Skipped: skipped})
+}
+
+func (s *server) handleCrossings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var thresholds []float64
+	if !decodeInto(w, r, &thresholds, maxCrossings*32, false) {
+		return
+	}
+	if len(thresholds) > maxCrossings {
+		http.Error(w, fmt.Sprintf("Too many thresholds: at most %d allowed", maxCrossings), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.trackerFor(r).NextCrossings(thresholds))
+}
+
+// handleStream pushes the current caffeine level as a server-sent event on
+// connect and after every change, with a comment line as keepalive.
+func (s *server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	changes, unsubscribe := tracker.Subscribe()
+	defer unsubscribe()
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	send := func() {
+		config := tracker.Config()
+		level, clamped := tracker.PlausibleLevelAt(tracker.Now())
+		data, _ := json.Marshal(levelResponse{
+			Level:      config.Display(level),
+			Unit:       config.DisplayUnit,
+			Clamped:    clamped,
+			Thresholds: config.DisplayThresholds(),
+		})
+		fmt.Fprintf(w, "event: level\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	send()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changes:
+			send()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// pollResponse is the current caffeine level with the version it reflects
+type pollResponse struct {
+	levelResponse
+	Version uint64 `json:"version"`
+}
+
+// handlePoll is a long-poll fallback for clients behind proxies that break
+// streaming. It answers once the version differs from ?since=, or at once
+// without since. If nothing changes within pollTimeout it answers 304 Not
+// Modified and the client polls again with the same since.
+func (s *server) handlePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Subscribe before reading the version so no change can slip in between
+	changes, unsubscribe := tracker.Subscribe()
+	defer unsubscribe()
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since: must be a version from a previous response", http.StatusBadRequest)
+			return
+		}
+		if tracker.Version() == since {
+			timeout := time.NewTimer(pollTimeout)
+			defer timeout.Stop()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timeout.C:
+				w.WriteHeader(http.StatusNotModified)
+				return
+			case <-changes:
+			}
+		}
+	}
+
+	version := tracker.Version()
+	config := tracker.Config()
+	level, clamped := tracker.PlausibleLevelAt(tracker.Now())
+	setUnitHeader(w, config)
+	writeJSON(w, http.StatusOK, pollResponse{
+		levelResponse: levelResponse{
+			Level:      config.Display(level),
+			Unit:       config.DisplayUnit,
+			Clamped:    clamped,
+			Thresholds: config.DisplayThresholds(),
+		},
+		Version: version,
+	})
+}
+
+// handleBedtimeICS serves the safe-to-sleep time as a calendar feed. The
+// calendar is empty if it is already safe or won't be within the projection
+// horizon.
+func (s *server) handleBedtimeICS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	// Already safe means the search returned the moment it started, so read
+	// the clock afterwards
+	at, ok := tracker.SafeToSleepAt()
+	now := tracker.Now()
+	var clear *time.Time
+	if ok && at.After(now) {
+		clear = &at
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="bedtime.ics"`)
+	link := s.externalURL(r, "/forecast.html")
+	io.WriteString(w, bedtimeCalendar(clear, now, tracker.Config().SleepThresholdMg, link))
+}
+
+// handleDebugLevel breaks the caffeine level at ?at= (RFC3339, default now)
+// down by event.
+func (s *server) handleDebugLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	at := tracker.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid at: must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		at = parsed
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, tracker.LevelBreakdownAt(at))
+}
+
+// solveResponse is when the caffeine level reaches a target
+type solveResponse struct {
+	LevelMg float64    `json:"levelMg"`
+	Time    *time.Time `json:"time"` // nil if the level never gets there
+}
+
+func (s *server) handleSolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target, err := strconv.ParseFloat(r.URL.Query().Get("level"), 64)
+	if err != nil || math.IsNaN(target) || math.IsInf(target, 0) || target < 0 {
+		http.Error(w, "Invalid level: must be a non-negative number of mg", http.StatusBadRequest)
+		return
+	}
+	tracker := s.trackerFor(r)
+	after := tracker.Now()
+	if v := r.URL.Query().Get("after"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid after: must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		after = parsed
+	}
+
+	resp := solveResponse{LevelMg: target}
+	if at, ok := tracker.TimeAtLevel(target, after); ok {
+		resp.Time = &at
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleSimulate forecasts a planned day of drinks without logging them.
+func (s *server) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, ok := decodeJSON[SimulationRequest](w, r)
+	if !ok {
+		return
+	}
+	if !checkPlannedDrinks(w, req.Drinks) {
+		return
+	}
+
+	tracker := s.trackerFor(r)
+	writeSimulation(w, tracker.Config(), tracker.Simulate(req))
+}
+
+// checkPlannedDrinks validates the drinks of a plan, writing an error
+// response and returning false if they are unusable.
+func checkPlannedDrinks(w http.ResponseWriter, drinks []PlannedDrink) bool {
+	if len(drinks) > maxPlannedDrinks {
+		http.Error(w, fmt.Sprintf("Too many drinks: at most %d allowed", maxPlannedDrinks), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	for i, drink := range drinks {
+		if drink.Amount <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid drink %d: amount must be a positive number of mg", i), http.StatusBadRequest)
+			return false
+		}
+		if drink.Time.IsZero() {
+			http.Error(w, fmt.Sprintf("Invalid drink %d: time must be an RFC3339 timestamp", i), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// writeSimulation writes sim with its levels in the display unit.
+func writeSimulation(w http.ResponseWriter, config Config, sim Simulation) {
+	sim.Forecast = displayForecast(w, config, sim.Forecast)
+	sim.Peak.Caffeine = config.Display(sim.Peak.Caffeine)
+	writeJSON(w, http.StatusOK, sim)
+}
+
+// handleScenarios lists the saved what-if scenarios, or saves one under
+// its name, replacing any with the same name.
+func (s *server) handleScenarios(w http.ResponseWriter, r *http.Request) {
+	tracker := s.trackerFor(r)
+	switch r.Method {
+	case http.MethodGet:
+		scenarios, err := tracker.Scenarios()
+		if err != nil {
+			fmt.Printf("Error reading scenarios: %v\n", err)
+			http.Error(w, "Failed to read scenarios", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, scenarios)
+	case http.MethodPost:
+		scenario, ok := decodeJSON[Scenario](w, r)
+		if !ok {
+			return
+		}
+		if err := validateScenarioName(scenario.Name); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !checkPlannedDrinks(w, scenario.Drinks) {
+			return
+		}
+		err := tracker.SaveScenario(scenario)
+		if errors.Is(err, errTooManyScenarios) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error saving scenario: %v\n", err)
+			http.Error(w, "Failed to save scenario", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, scenario)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleScenario returns or deletes the scenario named in the path.
+func (s *server) handleScenario(w http.ResponseWriter, r *http.Request) {
+	tracker := s.trackerFor(r)
+	name := r.PathValue("name")
+	var scenario Scenario
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		scenario, err = tracker.Scenario(name)
+	case http.MethodDelete:
+		err = tracker.DeleteScenario(name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, errScenarioNotFound) {
+		http.Error(w, "Scenario not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error accessing scenario %q: %v\n", name, err)
+		http.Error(w, "Failed to access scenario", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, scenario)
+}
+
+// handleScenarioForecast simulates the named scenario on top of the logged
+// history, as /api/simulate does with includeHistory.
+func (s *server) handleScenarioForecast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	sim, err := tracker.ForecastScenario(r.PathValue("name"))
+	if errors.Is(err, errScenarioNotFound) {
+		http.Error(w, "Scenario not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reading scenario: %v\n", err)
+		http.Error(w, "Failed to read scenario", http.StatusInternalServerError)
+		return
+	}
+	writeSimulation(w, tracker.Config(), sim)
+}
+
+// coverageResponse is how much of a window the level stays within a band
+type coverageResponse struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	LowMg    float64   `json:"lowMg"`
+	HighMg   *float64  `json:"highMg"`   // nil if the band has no upper bound
+	Coverage float64   `json:"coverage"` // Fraction of the window within the band, 0 to 1
+}
+
+// handleCoverage scores how well the caffeine level stays within [low, high]
+// over today's window from ?from= to ?to= (HH:MM). A window ending at or
+// before its start runs past midnight. low defaults to alertFloorMg and
+// high to no upper bound.
+func (s *server) handleCoverage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	config := tracker.Config()
+	query := r.URL.Query()
+	loc, err := requestLocation(r, config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := tracker.Now()
+	from, err := clockTimeOn(query.Get("from"), now, loc)
+	if err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := clockTimeOn(query.Get("to"), now, loc)
+	if err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !to.After(from) {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	low, high := config.AlertFloorMg, math.Inf(1)
+	if v := query.Get("low"); v != "" {
+		if low, err = strconv.ParseFloat(v, 64); err != nil || math.IsNaN(low) || math.IsInf(low, 0) || low < 0 {
+			http.Error(w, "Invalid low: must be a non-negative number of mg", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("high"); v != "" {
+		if high, err = strconv.ParseFloat(v, 64); err != nil || math.IsNaN(high) || math.IsInf(high, 0) || high < low {
+			http.Error(w, "Invalid high: must be a number of mg not below low", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// roundTo is meant for mg; a fraction needs more digits to be useful
+	coverage := math.Round(tracker.BandCoverage(from, to, low, high)*1000) / 1000
+	resp := coverageResponse{From: from, To: to, LowMg: low, Coverage: coverage}
+	if !math.IsInf(high, 1) {
+		resp.HighMg = &high
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// healthzResponse is the health of the process
+type healthzResponse struct {
+	Status       string `json:"status"`       // "ok", or "degraded" while a store is unavailable
+	Degraded     bool   `json:"degraded"`     // A store is unavailable and changes are kept in memory
+	FutureEvents int    `json:"futureEvents"` // Future-dated drinks found by the last hourly self-check
+}
+
+// handleHealthz reports that the process is up. It only reads flags kept by
+// the stores and the self-check, so it stays cheap and reliable for
+// liveness probes, and answers 200 even when degraded: restarting would
+// lose the changes kept in memory.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	resp := healthzResponse{
+		Status:       "ok",
+		Degraded:     s.profiles.degraded(),
+		FutureEvents: s.profiles.futureEvents(),
+	}
+	if resp.Degraded {
+		resp.Status = "degraded"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// pingResponse reports how long a representative level calculation took
+type pingResponse struct {
+	OK            bool  `json:"ok"`
+	ComputeMicros int64 `json:"computeMicros"`
+}
+
+func (s *server) handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := s.trackerFor(r)
+	start := time.Now()
+	tracker.CalculateCaffeineLevelAt(tracker.Now())
+	elapsed := time.Since(start)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, pingResponse{OK: true, ComputeMicros: elapsed.Microseconds()})
+}
+
+// underBasePath serves next below prefix, stripping it from request paths.
+// The bare prefix redirects to prefix + "/" so relative links in the pages
+// resolve below it; anything outside the prefix is not found.
+func underBasePath(prefix string, next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, next))
+	mux.Handle(prefix, http.RedirectHandler(prefix+"/", http.StatusMovedPermanently))
+	return mux
+}
+
+// normalizeBasePath turns a -base-path value into the form used for
+// routing: a leading slash, no trailing slash, and "" for the root.
+func normalizeBasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// externalURL returns the absolute URL of path on this server as the
+// client reached it, including the base path.
+func (s *server) externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.basePath + path
+}
+
+// setUnitHeader tells the client which unit caffeine amounts in the response use.
+func setUnitHeader(w http.ResponseWriter, config Config) {
+	w.Header().Set("X-Caffeine-Unit", config.DisplayUnit)
+}
+
+// timedETag builds a weak ETag for responses that depend on both the stored
+// data and the current time. It changes on every mutation and every minute.
+func timedETag(version uint64, now time.Time) string {
+	return fmt.Sprintf(`W/"v%d-t%d"`, version, now.Unix()/60)
+}
+
+// notModified sets the ETag header and, if the client already holds that
+// version, responds 304 Not Modified and returns true.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSON reads a request body holding a single JSON value of type T.
+// Fields T doesn't know are rejected. On failure it writes the error
+// response and returns ok=false.
+func decodeJSON[T any](w http.ResponseWriter, r *http.Request) (T, bool) {
+	return decodeBody[T](w, r, false)
+}
+
+// decodeBody is decodeJSON, except that with optional set an empty body is
+// accepted and decodes to the zero value. Bodies are limited to
+// maxRequestBytes.
+func decodeBody[T any](w http.ResponseWriter, r *http.Request, optional bool) (v T, ok bool) {
+	ok = decodeInto(w, r, &v, maxRequestBytes, optional)
+	return v, ok
+}
+
+// decodeInto is decodeBody for the value v points to, with a body limit of
+// limit bytes. Fields the body leaves out keep their values in v, so a
+// PATCH can decode onto the current state.
+func decodeInto(w http.ResponseWriter, r *http.Request, v any, limit int64, optional bool) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, io.EOF) && optional:
+		return true
+	case errors.Is(err, io.EOF):
+		http.Error(w, "Invalid request body: request body is required", http.StatusBadRequest)
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Request body too large: at most %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		http.Error(w, "Invalid request body: "+strings.TrimPrefix(err.Error(), "json: "), http.StatusBadRequest)
+	default:
+		http.Error(w, "Invalid request body: malformed JSON", http.StatusBadRequest)
+	}
+	return false
+}
+
+// writeJSON encodes v as the JSON response body with the given status code.
+// If the client asked for an envelope, v is wrapped with response metadata;
+// if it asked for pretty output, the JSON is indented.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	if ew, ok := findWriter[*envelopeWriter](w); ok {
+		v = dataEnvelope{Data: v, Meta: ew.meta()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	newJSONEncoder(w).Encode(v)
+}
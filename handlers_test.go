@@ -0,0 +1,302 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoostRejectsNonFiniteAmounts(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, amount := range []string{"NaN", "Inf", "+Inf", "-Inf", "0", "-5", "abc"} {
+		rec := do(handler, http.MethodPost, "/api/boost?amount="+amount, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("amount=%s: status %d, want 400", amount, rec.Code)
+		}
+	}
+	if events := tracker.GetEvents(); len(events) != 0 {
+		t.Fatalf("rejected boosts stored %d events", len(events))
+	}
+
+	rec := do(handler, http.MethodPost, "/api/boost?amount=80", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("amount=80: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := do(handler, http.MethodGet, "/api/caffeine-level", nil); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("caffeine-level after boost: status %d, body %q", rec.Code, rec.Body)
+	}
+}
+
+func TestOverrideRejectsNonFiniteLevels(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, level := range []string{"NaN", "Inf", "-Inf", "-1", ""} {
+		rec := do(handler, http.MethodPost, "/api/override?level="+level, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("level=%s: status %d, want 400", level, rec.Code)
+		}
+	}
+	if events := tracker.GetEvents(); len(events) != 0 {
+		t.Fatalf("rejected overrides stored %d events", len(events))
+	}
+}
+
+func TestTopUpRejectsNonFiniteTargets(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, target := range []string{"NaN", "Inf", "-1", ""} {
+		if rec := do(handler, http.MethodGet, "/api/topup?target="+target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("target=%s: status %d, want 400", target, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/topup?target=100", nil); rec.Code != http.StatusOK {
+		t.Errorf("target=100: status %d, want 200", rec.Code)
+	}
+}
+
+func TestSolveRejectsNonFiniteLevels(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	mustAdd(t, tracker, testStart, 200)
+	handler := newTestServer(t, tracker)
+
+	for _, level := range []string{"NaN", "Inf", "-Inf", "-1"} {
+		if rec := do(handler, http.MethodGet, "/api/solve?level="+level, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("level=%s: status %d, want 400", level, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/solve?level=50", nil); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("level=50: status %d, body %q", rec.Code, rec.Body)
+	}
+}
+
+func TestCaffeineLevelRejectsNonFiniteHalfLife(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	mustAdd(t, tracker, testStart, 100)
+	handler := newTestServer(t, tracker)
+
+	for _, halfLife := range []string{"NaN", "Inf", "0", "-2"} {
+		if rec := do(handler, http.MethodGet, "/api/caffeine-level?halfLife="+halfLife, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("halfLife=%s: status %d, want 400", halfLife, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/caffeine-level?halfLife=3", nil); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("halfLife=3: status %d, body %q", rec.Code, rec.Body)
+	}
+}
+
+func TestAlertCheckRejectsNonFiniteMin(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, min := range []string{"NaN", "Inf", "-Inf", "-1"} {
+		if rec := do(handler, http.MethodGet, "/api/alert-check?min="+min, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("min=%s: status %d, want 400", min, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/alert-check?min=40", nil); rec.Code != http.StatusOK {
+		t.Errorf("min=40: status %d, want 200", rec.Code)
+	}
+}
+
+func TestSuggestRejectsNonFiniteFloor(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, floor := range []string{"NaN", "Inf", "-Inf", "-1"} {
+		if rec := do(handler, http.MethodGet, "/api/suggest?floor="+floor, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("floor=%s: status %d, want 400", floor, rec.Code)
+		}
+	}
+}
+
+func TestCoverageRejectsNonFiniteBounds(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, query := range []string{"low=NaN", "low=Inf", "low=-Inf", "high=NaN", "high=Inf", "low=100&high=50"} {
+		if rec := do(handler, http.MethodGet, "/api/coverage?from=08:00&to=18:00&"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/coverage?from=08:00&to=18:00&low=50&high=200", nil); rec.Code != http.StatusOK {
+		t.Errorf("low=50&high=200: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCrashRejectsNonFiniteThreshold(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, threshold := range []string{"NaN", "Inf", "-Inf", "0"} {
+		if rec := do(handler, http.MethodGet, "/api/crash?threshold="+threshold, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("threshold=%s: status %d, want 400", threshold, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/crash?threshold=20", nil); rec.Code != http.StatusOK {
+		t.Errorf("threshold=20: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestActiveRejectsNonFiniteMin(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, min := range []string{"NaN", "Inf", "-Inf", "-1"} {
+		if rec := do(handler, http.MethodGet, "/api/active?min="+min, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("min=%s: status %d, want 400", min, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/active?min=5", nil); rec.Code != http.StatusOK {
+		t.Errorf("min=5: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestBodiesAreStrictAndSizeLimited(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	event := mustAdd(t, tracker, testStart, 80)
+	handler := newTestServer(t, tracker)
+	huge := `{"note":"` + strings.Repeat("x", maxRequestBytes) + `"}`
+
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{http.MethodPatch, "/api/events/" + event.ID, `{"amount":90,"bogus":1}`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/events/" + event.ID, huge, http.StatusRequestEntityTooLarge},
+		{http.MethodPatch, "/api/config", `{"roundTo":2,"bogus":1}`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/config", `{"roundTo":2} {}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/goal", `{"bogus":1}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/goal", huge, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/api/levels", `"2024-05-15T10:00:00Z"`, http.StatusBadRequest},
+		{http.MethodPost, "/api/calibrate", `[{"time":"2024-05-15T10:00:00Z","bogus":1}]`, http.StatusBadRequest},
+		{http.MethodPost, "/api/crossings", ``, http.StatusBadRequest},
+		{http.MethodPost, "/api/import", `[] []`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := do(handler, tt.method, tt.target, strings.NewReader(tt.body))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.target, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	rec := do(handler, http.MethodPatch, "/api/events/"+event.ID, strings.NewReader(`{"amount":90}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH amount: status %d: %s", rec.Code, rec.Body)
+	}
+	if got, _ := tracker.Event(event.ID); got.Amount != 90 || !got.Time.Equal(event.Time) {
+		t.Errorf("patched event = %+v, want amount 90 at %v", got, event.Time)
+	}
+}
+
+func TestEventsETagFollowsSharedStore(t *testing.T) {
+	store := newMemoryStore()
+	clock := newFakeClock(testStart)
+	writer, reader := NewTrackerWithStore(store, clock), NewTrackerWithStore(store, clock)
+	mustAdd(t, writer, testStart, 80)
+	handler := newTestServer(t, reader)
+
+	etag := do(handler, http.MethodGet, "/api/events", nil).Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("unchanged events: status %d, want 304", rec.Code)
+	}
+
+	// A drink logged through another tracker on the same store changes it
+	clock.Advance(time.Minute)
+	event := mustAdd(t, writer, clock.Now(), 60)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), event.ID) {
+		t.Fatalf("after another server's change: status %d, want 200 with %s: %s", rec.Code, event.ID, rec.Body)
+	}
+
+	// So does an edit that keeps the count
+	etag = rec.Header().Get("ETag")
+	clock.Advance(time.Minute)
+	event.Amount = 70
+	if _, err := writer.UpdateEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if got := do(handler, http.MethodGet, "/api/events", nil).Header().Get("ETag"); got == etag {
+		t.Errorf("ETag %s unchanged after an edit", got)
+	}
+}
+
+func TestPatchEventEditsOnlyItsFields(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	event := mustAdd(t, tracker, testStart, 80)
+	handler := newTestServer(t, tracker)
+
+	for _, body := range []string{`{"override":true}`, `{"emptyStomach":true}`, `{"id":"other"}`, `{"amount":-10}`} {
+		if rec := do(handler, http.MethodPatch, "/api/events/"+event.ID, strings.NewReader(body)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if got, _ := tracker.Event(event.ID); got.Override || got.EmptyStomach || got.Amount != 80 {
+		t.Errorf("rejected patches changed the event: %+v", got)
+	}
+
+	clock.Advance(time.Hour)
+	override, err := tracker.Override(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := do(handler, http.MethodPatch, "/api/events/"+override.ID, strings.NewReader(`{"amount":-20,"note":"felt flat"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH override: status %d: %s", rec.Code, rec.Body)
+	}
+	if got, _ := tracker.Event(override.ID); got.Amount != -20 || !got.Override || got.Note != "felt flat" {
+		t.Errorf("patched override = %+v, want amount -20 with the note", got)
+	}
+}
+
+func TestAddCoffeeRejectsNonPositiveAmounts(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	for _, body := range []string{`{"amount":-50}`, `{"amount":-0.1}`, `{"amount":1e999}`, `{"amount":"NaN"}`} {
+		if rec := do(handler, http.MethodPost, "/api/add-coffee", strings.NewReader(body)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if events := tracker.GetEvents(); len(events) != 0 {
+		t.Fatalf("rejected drinks stored %d events", len(events))
+	}
+	if rec := do(handler, http.MethodPost, "/api/add-coffee", strings.NewReader(`{"amount":80}`)); rec.Code != http.StatusOK {
+		t.Errorf(`{"amount":80}: status %d, want 200`, rec.Code)
+	}
+}
+
+func TestAddCoffeeBodyErrors(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+
+	tests := []struct {
+		name, body string
+		status     int
+		message    string
+	}{
+		{"empty", "", http.StatusBadRequest, "request body is required"},
+		{"malformed", `{"amount":`, http.StatusBadRequest, "malformed JSON"},
+		{"not an object", `[80]`, http.StatusBadRequest, "malformed JSON"},
+		{"valid", `{"amount":80}`, http.StatusOK, `"status":"success"`},
+	}
+	for _, tt := range tests {
+		rec := do(handler, http.MethodPost, "/api/add-coffee", strings.NewReader(tt.body))
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.message) {
+			t.Errorf("%s body: %d %q, want %d containing %q", tt.name, rec.Code, rec.Body, tt.status, tt.message)
+		}
+	}
+	if events := tracker.GetEvents(); len(events) != 1 {
+		t.Errorf("%d events stored, want only the valid drink", len(events))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testStart is the time fake clocks start at: a Wednesday mid-morning in UTC.
+var testStart = time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+
+// do sends a request with an optional body to handler and returns the
+// recorded response.
+func do(handler http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// newTestTracker returns a memory-backed tracker on a fake clock.
+func newTestTracker(t *testing.T) (*Tracker, *fakeClock) {
+	t.Helper()
+	clock := newFakeClock(testStart)
+	return NewTrackerWithStore(newMemoryStore(), clock), clock
+}
+
+// mustAdd logs a drink of amount mg at the given time.
+func mustAdd(t *testing.T, tracker *Tracker, at time.Time, amount float64) CoffeeIntakeEvent {
+	t.Helper()
+	event, _, err := tracker.AddEvent(CoffeeIntakeEvent{Time: at, Amount: amount})
+	if err != nil {
+		t.Fatalf("AddEvent(%v, %g): %v", at, amount, err)
+	}
+	return event
+}
+
+// newTestServer returns the routes of a server whose default profile is
+// tracker.
+func newTestServer(t *testing.T, tracker *Tracker) http.Handler {
+	t.Helper()
+	open := func(string) (*Tracker, error) {
+		return NewTrackerWithStore(newMemoryStore(), tracker.clock), nil
+	}
+	return newServer(newProfiles(tracker, open), serverOptions{AccessLog: io.Discard}).routes()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const icsTimeLayout = "20060102T150405Z" // iCalendar UTC date-time
+
+// bedtimeCalendar renders an iCalendar document with a single event at the
+// time it becomes safe to sleep, with a reminder at that time and a link to
+// the forecast page. If clear is nil, the calendar has no events. The UID
+// only depends on the time, so a subscribed calendar updates rather than
+// duplicates an unchanged bedtime.
+func bedtimeCalendar(clear *time.Time, now time.Time, thresholdMg float64, link string) string {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Coffee-to-GO//Caffeine Tracker//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Caffeine bedtime",
+	}
+	if clear != nil {
+		at := clear.UTC().Truncate(time.Minute)
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:bedtime-%d@coffee-to-go", at.Unix()),
+			"DTSTAMP:"+now.UTC().Format(icsTimeLayout),
+			"DTSTART:"+at.Format(icsTimeLayout),
+			"DURATION:PT15M",
+			"SUMMARY:Safe to sleep",
+			fmt.Sprintf("DESCRIPTION:Caffeine level is at or below %g mg from now on.", thresholdMg),
+			"URL:"+link,
+			"TRANSP:TRANSPARENT",
+			"BEGIN:VALARM",
+			"ACTION:DISPLAY",
+			"DESCRIPTION:Safe to sleep",
+			"TRIGGER:PT0S",
+			"END:VALARM",
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentAddsGetDistinctIDs(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	const adds = 1000
+	var wg sync.WaitGroup
+	for range adds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tracker.AddDrink(10); err != nil {
+				t.Errorf("AddDrink: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, event := range tracker.GetEvents() {
+		if event.ID == "" || seen[event.ID] {
+			t.Fatalf("event ID %q is empty or repeated", event.ID)
+		}
+		seen[event.ID] = true
+	}
+	if len(seen) != adds {
+		t.Errorf("got %d distinct IDs, want %d", len(seen), adds)
+	}
+}
+
+func TestIDsAreUniqueAcrossGeneratorsAndSortByTime(t *testing.T) {
+	at := testStart
+	first, second := newIDGenerator(), newIDGenerator()
+	if a, b := first.Next(at), second.Next(at); a == b {
+		t.Errorf("two generators both returned %q for the same time", a)
+	}
+
+	later, earliest, middle := first.Next(at.Add(time.Hour)), first.Next(at), first.Next(at.Add(time.Minute))
+	ids := []string{later, earliest, middle}
+	sort.Strings(ids)
+	if ids[0] != earliest || ids[1] != middle || ids[2] != later {
+		t.Errorf("sorted IDs %v, want them in time order", ids)
+	}
+}
+
+func TestIDsSurviveReopening(t *testing.T) {
+	store := newMemoryStore()
+	tracker := NewTrackerWithStore(store, newFakeClock(testStart))
+	added := mustAdd(t, tracker, testStart, 80)
+
+	reopened := NewTrackerWithStore(store, newFakeClock(testStart))
+	events := reopened.GetEvents()
+	if len(events) != 1 || events[0].ID != added.ID {
+		t.Errorf("events after reopening = %+v, want ID %q", events, added.ID)
+	}
+	if next := mustAdd(t, reopened, testStart, 80); next.ID == added.ID {
+		t.Errorf("reopened tracker reused ID %q", next.ID)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const maxImportBytes = 10 << 20 // Largest import body accepted
+
+// importMapper converts one record of a foreign export into an event.
+type importMapper func(record json.RawMessage) (CoffeeIntakeEvent, error)
+
+// importMappers holds the known foreign export formats by name. To support
+// another app, add a mapper for its record schema here.
+var importMappers = map[string]importMapper{
+	"appX": mapAppXRecord,
+}
+
+// SkippedRecord explains why a record of an import was not stored.
+type SkippedRecord struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// ImportResult summarizes an import.
+type ImportResult struct {
+	Imported int             `json:"imported"`
+	Skipped  []SkippedRecord `json:"skipped"`
+}
+
+// mapRecords runs every record through the mapper and validates the
+// result, separating usable events from skipped records.
+func mapRecords(records []json.RawMessage, mapper importMapper) ([]CoffeeIntakeEvent, []SkippedRecord) {
+	events := make([]CoffeeIntakeEvent, 0, len(records))
+	skipped := make([]SkippedRecord, 0)
+	for i, record := range records {
+		event, err := mapper(record)
+		if err == nil {
+			err = validateImported(event)
+		}
+		if err != nil {
+			skipped = append(skipped, SkippedRecord{Index: i, Reason: err.Error()})
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, skipped
+}
+
+// validateImported rejects events that would corrupt the history.
+func validateImported(event CoffeeIntakeEvent) error {
+	if event.Time.IsZero() {
+		return errors.New("missing timestamp")
+	}
+	if math.IsNaN(event.Amount) || math.IsInf(event.Amount, 0) || event.Amount <= 0 {
+		return errors.New("amount must be a positive number")
+	}
+	return validateNote(event.Note)
+}
+
+// mapNativeRecord decodes an event as written by the JSON export.
+func mapNativeRecord(raw json.RawMessage) (CoffeeIntakeEvent, error) {
+	var event CoffeeIntakeEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("malformed record: %v", err)
+	}
+	return event, nil
+}
+
+// appXRecord is one entry of the other app's JSON export. The timestamp is
+// either an RFC3339 string or Unix seconds.
+type appXRecord struct {
+	Timestamp json.RawMessage `json:"timestamp"`
+	Mg        float64         `json:"mg"`
+}
+
+func mapAppXRecord(raw json.RawMessage) (CoffeeIntakeEvent, error) {
+	var record appXRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("malformed record: %v", err)
+	}
+	at, err := parseFlexibleTime(record.Timestamp)
+	if err != nil {
+		return CoffeeIntakeEvent{}, err
+	}
+	return CoffeeIntakeEvent{Time: at, Amount: record.Mg}, nil
+}
+
+// parseFlexibleTime accepts a JSON RFC3339 string or a number of Unix seconds.
+func parseFlexibleTime(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, errors.New("missing timestamp")
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		at, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+		}
+		return at, nil
+	}
+	seconds, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %s", raw)
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// lookupMinScore is the lowest match score at which a drink name is
+// resolved to an amount. Weaker matches are reported but not used.
+const lookupMinScore = 0.6
+
+// KnownDrink is an entry of the drink table that free-form names are
+// matched against.
+type KnownDrink struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type,omitempty"`
+	Amount  float64  `json:"amount"`
+	Aliases []string `json:"aliases,omitempty"` // Other names it goes by
+}
+
+// builtinDrinks is the table of common drinks. Amounts are typical values
+// in mg; chains and recipes vary. To add a drink, append it here, or per
+// user in Config.Drinks.
+var builtinDrinks = []KnownDrink{
+	{Name: "Espresso", Type: "coffee", Amount: 63, Aliases: []string{"single espresso", "single shot"}},
+	{Name: "Double espresso", Type: "coffee", Amount: 126, Aliases: []string{"doppio", "double shot"}},
+	{Name: "Ristretto", Type: "coffee", Amount: 63},
+	{Name: "Americano", Type: "coffee", Amount: 126},
+	{Name: "Filter coffee", Type: "coffee", Amount: 95, Aliases: []string{"coffee", "drip coffee", "brewed coffee", "black coffee"}},
+	{Name: "Cold brew", Type: "coffee", Amount: 155},
+	{Name: "Instant coffee", Type: "coffee", Amount: 62},
+	{Name: "Decaf coffee", Type: "coffee", Amount: 3, Aliases: []string{"decaf"}},
+	{Name: "Latte", Type: "coffee", Amount: 75, Aliases: []string{"caffe latte"}},
+	{Name: "Tall latte", Type: "coffee", Amount: 75},
+	{Name: "Grande latte", Type: "coffee", Amount: 150},
+	{Name: "Venti latte", Type: "coffee", Amount: 150},
+	{Name: "Cappuccino", Type: "coffee", Amount: 75},
+	{Name: "Grande cappuccino", Type: "coffee", Amount: 150},
+	{Name: "Flat white", Type: "coffee", Amount: 130},
+	{Name: "Cortado", Type: "coffee", Amount: 126},
+	{Name: "Macchiato", Type: "coffee", Amount: 63},
+	{Name: "Mocha", Type: "coffee", Amount: 95, Aliases: []string{"caffe mocha"}},
+	{Name: "Black tea", Type: "tea", Amount: 47, Aliases: []string{"tea", "english breakfast"}},
+	{Name: "Green tea", Type: "tea", Amount: 28},
+	{Name: "Matcha", Type: "tea", Amount: 70, Aliases: []string{"matcha latte"}},
+	{Name: "Chai latte", Type: "tea", Amount: 50, Aliases: []string{"chai"}},
+	{Name: "Yerba mate", Type: "tea", Amount: 80, Aliases: []string{"mate"}},
+	{Name: "Cola", Type: "soda", Amount: 34, Aliases: []string{"coke", "coca cola"}},
+	{Name: "Diet cola", Type: "soda", Amount: 46, Aliases: []string{"diet coke", "coke zero"}},
+	{Name: "Energy drink", Type: "energy", Amount: 80, Aliases: []string{"red bull"}},
+	{Name: "Large energy drink", Type: "energy", Amount: 160, Aliases: []string{"monster"}},
+	{Name: "Hot chocolate", Type: "cocoa", Amount: 5, Aliases: []string{"cocoa"}},
+}
+
+// DrinkMatch is the table entry that best matches a name, with a score
+// from 0 (nothing in common) to 1 (same words).
+type DrinkMatch struct {
+	Drink     KnownDrink `json:"drink"`
+	Score     float64    `json:"score"`
+	Confident bool       `json:"confident"` // Whether Score reaches lookupMinScore
+}
+
+// LookupDrink matches name against the user's drinks and then the built-in
+// table, returning ok=false if no entry shares a word with it. Names are
+// compared as sets of words, so word order, case and punctuation don't
+// matter, and words of four letters or more may contain one typo. On equal
+// scores the user's drinks win.
+func (t *Tracker) LookupDrink(name string) (match DrinkMatch, ok bool) {
+	words := nameWords(name)
+	if len(words) == 0 {
+		return DrinkMatch{}, false
+	}
+	for _, drink := range append(t.Config().Drinks, builtinDrinks...) {
+		for _, candidate := range append([]string{drink.Name}, drink.Aliases...) {
+			if score := wordOverlap(words, nameWords(candidate)); score > match.Score {
+				match = DrinkMatch{Drink: drink, Score: score}
+			}
+		}
+	}
+	match.Confident = match.Score >= lookupMinScore
+	return match, match.Score > 0
+}
+
+// nameWords splits a drink name into lower-case words.
+func nameWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// wordOverlap scores how alike two names are: the words they share over
+// the words in either (Jaccard index).
+func wordOverlap(a, b []string) float64 {
+	shared := 0
+	for _, wa := range a {
+		for _, wb := range b {
+			if similarWords(wa, wb) {
+				shared++
+				break
+			}
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// similarWords reports whether a and b are the same word, allowing one
+// inserted, deleted or changed letter in words of four letters or more.
+func similarWords(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ra, rb := []rune(a), []rune(b)
+	if min(len(ra), len(rb)) < 4 {
+		return false
+	}
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb)-len(ra) > 1 {
+		return false
+	}
+	// Skip the common prefix and suffix; at most one letter may remain
+	i := 0
+	for i < len(ra) && ra[i] == rb[i] {
+		i++
+	}
+	j := 0
+	for j < len(ra)-i && ra[len(ra)-1-j] == rb[len(rb)-1-j] {
+		j++
+	}
+	return len(rb)-i-j <= 1
+}
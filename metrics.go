@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// openMetricsContentType is the media type of OpenMetrics text.
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// WriteDailyMetrics writes the daily totals as timestamped OpenMetrics
+// samples, one per day and series, stamped with the start of the day. The
+// output can be backfilled into Prometheus with
+// "promtool tsdb create-blocks-from openmetrics".
+func WriteDailyMetrics(w io.Writer, days []DayRollup) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# TYPE caffeine_daily_intake_mg gauge")
+	fmt.Fprintln(bw, "# UNIT caffeine_daily_intake_mg mg")
+	fmt.Fprintln(bw, "# HELP caffeine_daily_intake_mg Caffeine logged during the stats day.")
+	for _, day := range days {
+		fmt.Fprintf(bw, "caffeine_daily_intake_mg %s %d\n", strconv.FormatFloat(day.TotalMg, 'g', -1, 64), day.Day.Unix())
+	}
+	fmt.Fprintln(bw, "# TYPE caffeine_daily_drinks gauge")
+	fmt.Fprintln(bw, "# HELP caffeine_daily_drinks Drinks logged during the stats day.")
+	for _, day := range days {
+		fmt.Fprintf(bw, "caffeine_daily_drinks %d %d\n", day.Drinks, day.Day.Unix())
+	}
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRequestIDLen is the longest client-supplied request ID that is accepted.
+const maxRequestIDLen = 128
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// withRequestID gives every request a correlation ID: the client's
+// X-Request-ID if it sent a usable one, otherwise a new random one. The ID
+// is stored in the request context and echoed in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the correlation ID of a request, or "" if it has none.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so they
+// can't break log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequests writes one access log line per request to logger.
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		logger.Info("request",
+			"request_id", requestID(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 response, logging the
+// panic with its stack trace instead of crashing the server. If the
+// response has already started, e.g. a stream or a flushed gzip body, a
+// JSON error can't be appended to it, so the connection is aborted instead.
+func recoverPanics(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// Deliberate aborts are handled by net/http itself
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			logger.Error("panic",
+				"request_id", requestID(r),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"error", fmt.Sprint(err),
+				"stack", string(debug.Stack()),
+			)
+			if rec.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder remembers the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = status, true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// prettyWriter marks a response whose JSON should be indented for reading.
+type prettyWriter struct {
+	http.ResponseWriter
+}
+
+func (w *prettyWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *prettyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// prettyJSON makes writeJSON indent its output for requests with
+// ?pretty=true or an X-Pretty: true header. Responses stay compact by default.
+func prettyJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+		if header, err := strconv.ParseBool(r.Header.Get("X-Pretty")); err == nil {
+			pretty = pretty || header
+		}
+		if !pretty {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&prettyWriter{ResponseWriter: w}, r)
+	})
+}
+
+// findWriter looks for a writer of type T in the Unwrap chain of w, so
+// marker writers are found below other middleware wrappers.
+func findWriter[T http.ResponseWriter](w http.ResponseWriter) (T, bool) {
+	for {
+		if found, ok := w.(T); ok {
+			return found, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// newJSONEncoder returns an encoder for a response body written to w,
+// indenting if the response is marked by prettyJSON.
+func newJSONEncoder(w http.ResponseWriter) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if _, ok := findWriter[*prettyWriter](w); ok {
+		enc.SetIndent("", "  ")
+	}
+	return enc
+}
+
+// readOnlyQueries are the non-GET routes that never change state: they
+// compute an answer from the request body.
+var readOnlyQueries = map[string]bool{
+	"POST /api/levels":    true,
+	"POST /api/crossings": true,
+	"POST /api/simulate":  true,
+}
+
+// readOnly rejects every request that could change state with 403
+// Forbidden: anything but GET, HEAD and OPTIONS, unless listed in
+// readOnlyQueries.
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !readOnlyQueries[r.Method+" "+r.URL.Path] {
+				http.Error(w, "Server is in read-only mode", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsOrigins is the set of origins allowed to call the API from a browser.
+type corsOrigins struct {
+	any     bool // "*" was listed: allow every origin
+	allowed map[string]bool
+}
+
+// parseCORSOrigins parses a comma-separated list of origins such as
+// "http://localhost:3000,https://coffee.example.com". "*" allows any origin.
+// An empty list disables CORS.
+func parseCORSOrigins(list string) corsOrigins {
+	origins := corsOrigins{allowed: make(map[string]bool)}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		switch origin {
+		case "":
+		case "*":
+			origins.any = true
+		default:
+			origins.allowed[origin] = true
+		}
+	}
+	return origins
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers
+// preflight requests. A listed origin is echoed back; with "*" the
+// response allows any origin. Requests from other origins get no CORS
+// headers, so browsers block them.
+func corsMiddleware(origins corsOrigins, next http.Handler) http.Handler {
+	if !origins.any && len(origins.allowed) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		switch {
+		case origins.any:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origins.allowed[origin]:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, X-Pretty, X-Profile, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Caffeine-Unit, X-Request-ID")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gzipMinSize is the smallest JSON response (in bytes) worth compressing.
+const gzipMinSize = 1024
+
+// gzipMiddleware compresses JSON responses larger than gzipMinSize for clients
+// that accept gzip. Event streams are passed through untouched so they can flush.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) || r.Header.Get("Accept") == "text/event-stream" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request negotiates gzip content encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// the body is JSON and large enough to compress, then either switches to a
+// gzip.Writer or passes everything through unchanged.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+	if !strings.HasPrefix(g.Header().Get("Content-Type"), "application/json") {
+		if err := g.passthrough(); err != nil {
+			return 0, err
+		}
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// startGzip commits to a compressed response and writes the buffered bytes.
+func (g *gzipResponseWriter) startGzip() error {
+	g.decided = true
+	h := g.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// passthrough commits to an uncompressed response and writes the buffered bytes.
+func (g *gzipResponseWriter) passthrough() error {
+	g.decided = true
+	g.ResponseWriter.WriteHeader(g.status)
+	if len(g.buf) == 0 {
+		return nil
+	}
+	_, err := g.ResponseWriter.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// Flush sends any buffered data to the client. A response that hasn't been
+// compressed yet is sent uncompressed so streaming handlers aren't held back.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		if g.status == 0 {
+			g.status = http.StatusOK
+		}
+		g.passthrough()
+	} else if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response, flushing small bodies uncompressed.
+func (g *gzipResponseWriter) Close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if !g.decided && g.status != 0 {
+		return g.passthrough()
+	}
+	return nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
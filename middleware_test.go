@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipMiddlewareRoundTrip(t *testing.T) {
+	body := `{"points":[` + strings.Repeat(`{"level":12.5},`, 200) + `{"level":0}]}`
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/forecast", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if rec.Body.Len() >= len(body) {
+		t.Errorf("compressed body is %d bytes, original %d", rec.Body.Len(), len(body))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	if string(decoded) != body {
+		t.Errorf("round-tripped body differs from the original")
+	}
+}
+
+func TestGzipMiddlewarePassesThrough(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		accept         string
+		contentType    string
+		body           string
+	}{
+		{"small json", "gzip", "", "application/json", `{"level":1}`},
+		{"no gzip", "identity", "", "application/json", strings.Repeat("x", 2*gzipMinSize)},
+		{"refused gzip", "gzip;q=0", "", "application/json", strings.Repeat("x", 2*gzipMinSize)},
+		{"not json", "gzip", "", "text/plain", strings.Repeat("x", 2*gzipMinSize)},
+		{"event stream", "gzip", "text/event-stream", "text/event-stream", strings.Repeat("x", 2*gzipMinSize)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				io.WriteString(w, tt.body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want none", got)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), []byte(tt.body)) {
+				t.Errorf("body was altered")
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareFlushSendsUncompressed(t *testing.T) {
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"partial":true}`)
+		w.(http.Flusher).Flush()
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if !rec.Flushed {
+		t.Error("Flush did not reach the underlying writer")
+	}
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != `{"partial":true}` {
+		t.Errorf("flushed response = %q (encoding %q), want it uncompressed",
+			rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestReadOnlyRejectsFlush(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/caffeine-level", http.StatusNoContent},
+		{http.MethodPost, "/api/levels", http.StatusNoContent},
+		{http.MethodPost, "/api/flush", http.StatusForbidden},
+		{http.MethodPost, "/api/add-coffee", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		readOnly(next).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := withRequestID(recoverPanics(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var settings map[string]int
+		settings["halfLife"] = 5 // A nil map write, like a buggy handler
+	})))
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/config", nil)
+	req.Header.Set("X-Request-ID", "panic-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `"error":"Internal server error"`) {
+		t.Errorf("body = %s, want a JSON error", body)
+	}
+	for _, want := range []string{`"msg":"panic"`, `"request_id":"panic-1"`, `"path":"/api/config"`, "assignment to entry in nil map", `"stack":"goroutine`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %s:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestRecoverPanicsLeavesAbortsToNetHTTP(t *testing.T) {
+	handler := recoverPanics(slog.New(slog.NewTextHandler(io.Discard, nil)), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler re-panicked", err)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/stream", nil))
+}
+
+func TestRecoverPanicsAbortsStartedResponses(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handlers := map[string]http.Handler{
+		"plain": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"level":`))
+			panic("half-written response")
+		}),
+		"gzip": gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"events":[`))
+			panic("half-written response")
+		})),
+	}
+	for name, h := range handlers {
+		t.Run(name, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			defer func() {
+				if err := recover(); err != http.ErrAbortHandler {
+					t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+				}
+				if strings.Contains(rec.Body.String(), "Internal server error") {
+					t.Errorf("JSON error appended to a started response: %q", rec.Body)
+				}
+				if !strings.Contains(logs.String(), "half-written response") {
+					t.Errorf("panic not logged:\n%s", logs.String())
+				}
+			}()
+			recoverPanics(logger, h).ServeHTTP(rec, req)
+		})
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name, list, origin string
+		wantAllow          string
+	}{
+		{"listed", "http://localhost:3000, https://coffee.example.com/", "https://coffee.example.com", "https://coffee.example.com"},
+		{"other listed", "http://localhost:3000,https://coffee.example.com", "http://localhost:3000", "http://localhost:3000"},
+		{"not listed", "http://localhost:3000", "https://evil.example.com", ""},
+		{"wildcard", "*", "https://anywhere.example.com", "*"},
+		{"disabled", "", "http://localhost:3000", ""},
+	}
+	for _, tt := range tests {
+		handler := corsMiddleware(parseCORSOrigins(tt.list), ok)
+		req := httptest.NewRequest(http.MethodGet, "/api/caffeine-level", nil)
+		req.Header.Set("Origin", tt.origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.name, got, tt.wantAllow)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want the request served", tt.name, rec.Code)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := corsMiddleware(parseCORSOrigins("http://localhost:3000"), http.NotFoundHandler())
+	for _, tt := range []struct {
+		origin string
+		status int
+	}{
+		{"http://localhost:3000", http.StatusNoContent},
+		{"https://evil.example.com", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "/api/add-coffee", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("preflight from %s: status %d, want %d", tt.origin, rec.Code, tt.status)
+		}
+		if allowed := rec.Header().Get("Access-Control-Allow-Methods") != ""; allowed != (tt.status == http.StatusNoContent) {
+			t.Errorf("preflight from %s: Access-Control-Allow-Methods = %q", tt.origin, rec.Header().Get("Access-Control-Allow-Methods"))
+		}
+	}
+}
+
+func TestCORSAllowsCustomHeaders(t *testing.T) {
+	handler := corsMiddleware(parseCORSOrigins("*"), http.NotFoundHandler())
+	send := func(method string) http.Header {
+		req := httptest.NewRequest(method, "/api/add-coffee", nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	allowed := send(http.MethodOptions).Get("Access-Control-Allow-Headers")
+	for _, name := range []string{"X-Pretty", "X-Request-ID"} {
+		if !strings.Contains(allowed, name) {
+			t.Errorf("Access-Control-Allow-Headers = %q, want %s listed", allowed, name)
+		}
+	}
+	if got := send(http.MethodPost).Get("Access-Control-Expose-Headers"); !strings.Contains(got, "X-Request-ID") {
+		t.Errorf("Access-Control-Expose-Headers = %q, want X-Request-ID listed", got)
+	}
+}
+
+func TestRequestIDRoundTrips(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	var logs bytes.Buffer
+	handler := newServer(newProfiles(tracker, nil), serverOptions{AccessLog: &logs}).routes()
+
+	send := func(id string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/caffeine-level", nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header().Get("X-Request-ID")
+	}
+
+	if got := send("trace-42"); got != "trace-42" {
+		t.Errorf("X-Request-ID = %q, want the client's trace-42 echoed", got)
+	}
+	if !strings.Contains(logs.String(), "request_id=trace-42") {
+		t.Errorf("access log is missing the request ID:\n%s", logs.String())
+	}
+
+	generated := send("")
+	if len(generated) != 32 {
+		t.Errorf("generated X-Request-ID = %q, want 32 hex digits", generated)
+	}
+	if other := send(""); other == generated {
+		t.Errorf("two requests both got ID %q", other)
+	}
+	for _, bad := range []string{"has space", "line\nbreak", strings.Repeat("x", maxRequestIDLen+1)} {
+		if got := send(bad); got == bad || len(got) != 32 {
+			t.Errorf("X-Request-ID %q answered with %q, want a generated ID", bad, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "sync"
+
+// Notifier fans out change signals to any number of subscribers. Signals
+// carry no payload: a subscriber re-reads whatever state it needs. Sends
+// never block, so a slow subscriber just sees several changes coalesced
+// into one signal.
+type Notifier struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+// NewNotifier creates a Notifier with no subscribers.
+func NewNotifier() *Notifier {
+	return &Notifier{subs: make(map[chan struct{}]struct{})}
+}
+
+// Subscribe registers a new subscriber. It returns the channel signalled on
+// every change and a function that unsubscribes and closes the channel.
+// The unsubscribe function is safe to call more than once.
+func (n *Notifier) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	n.subs[ch] = struct{}{}
+	n.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			delete(n.subs, ch)
+			n.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Notify signals all subscribers. A subscriber that already has a signal
+// pending is skipped.
+func (n *Notifier) Notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe registers for a signal after every change to the tracker's
+// events or config. See Notifier.Subscribe.
+func (t *Tracker) Subscribe() (<-chan struct{}, func()) {
+	return t.notifier.Subscribe()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllSubscribersAreSignalledByAMutation(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	const subscribers = 10
+	chans := make([]<-chan struct{}, subscribers)
+	for i := range chans {
+		ch, unsubscribe := tracker.Subscribe()
+		defer unsubscribe()
+		chans[i] = ch
+	}
+
+	mustAdd(t, tracker, clock.Now(), 80)
+	for i, ch := range chans {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber %d got no signal", i)
+		}
+	}
+}
+
+func TestNotifierCoalescesSignals(t *testing.T) {
+	n := NewNotifier()
+	ch, unsubscribe := n.Subscribe()
+	defer unsubscribe()
+
+	// Nobody is reading: Notify must not block
+	for range 5 {
+		n.Notify()
+	}
+	<-ch
+	select {
+	case <-ch:
+		t.Error("got a second signal, want the pending ones coalesced")
+	default:
+	}
+}
+
+func TestUnsubscribeRemovesAndClosesTheChannel(t *testing.T) {
+	n := NewNotifier()
+	ch, unsubscribe := n.Subscribe()
+	unsubscribe()
+	unsubscribe()
+
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+	n.Notify()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.subs) != 0 {
+		t.Errorf("%d subscribers left after unsubscribing", len(n.subs))
+	}
+}
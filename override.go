@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// overrideTag tags the calibration events stored by Override.
+const overrideTag = "override"
+
+// Override anchors the model to a stated level: it stores a calibration
+// event at now whose amount is the difference between level and the
+// current level, negative if the model is too high. Calibration events are
+// marked with Override and the "override" tag, take effect at once whatever
+// the absorption model, and decay exponentially with the default half-life
+// like a drink, so the curve proceeds from the anchor.
+//
+// The event is kept in the history and exports, but it is not a drink: it
+// is left out of day totals, the budget, stats, goals and intake warnings.
+func (t *Tracker) Override(level float64) (CoffeeIntakeEvent, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events, err := t.store.Events()
+	if err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("reading events: %w", err)
+	}
+	now := t.clock.Now()
+	event := CoffeeIntakeEvent{
+		Time:       now,
+		Amount:     level - caffeineLevelAt(events, now, t.config),
+		Tags:       []string{overrideTag},
+		Override:   true,
+		ID:         t.ids.Next(now),
+		ModifiedAt: now,
+	}
+	if err := t.store.Add(event); err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("storing override: %w", err)
+	}
+	t.invalidateRollupLocked(event.Time)
+	t.version++
+	t.notifier.Notify()
+	fmt.Printf("Overrode level to %.1f mg at %s (%+.1f mg)\n", level, now.Format(time.TimeOnly), event.Amount)
+	t.evictLocked()
+	return event, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOverrideIsNotIntake(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	drink := mustAdd(t, tracker, testStart, 100)
+	clock.Advance(time.Hour)
+	override, err := tracker.Override(300)
+	if err != nil {
+		t.Fatalf("Override: %v", err)
+	}
+	if override.Amount <= 0 {
+		t.Fatalf("override amount = %g, want positive", override.Amount)
+	}
+
+	if got := tracker.CalculateCaffeineLevelAt(clock.Now()); got < 299.9 || got > 300.1 {
+		t.Errorf("level after override = %g, want 300", got)
+	}
+	today := tracker.Today()
+	if today.Drinks != 1 || today.TotalMg != 100 {
+		t.Errorf("Today() = %d drinks, %g mg; want 1 drink, 100 mg", today.Drinks, today.TotalMg)
+	}
+	if budget := tracker.Budget(); budget.ConsumedMg != 100 {
+		t.Errorf("Budget().ConsumedMg = %g, want 100", budget.ConsumedMg)
+	}
+	if summary := tracker.Summary(clock.Now(), time.UTC); summary.TotalDrinks != 1 || summary.TotalMg != 100 {
+		t.Errorf("Summary() = %d drinks, %g mg; want 1 drink, 100 mg", summary.TotalDrinks, summary.TotalMg)
+	}
+	if got := tracker.IntakeInWindow(2 * time.Hour); got != 100 {
+		t.Errorf("IntakeInWindow = %g, want 100", got)
+	}
+	if tracker.IsFirstOfDay(override) || !tracker.IsFirstOfDay(drink) {
+		t.Error("the override took the first-of-day badge from the drink")
+	}
+
+	clock.Advance(24 * time.Hour)
+	days := tracker.FinishedDays(time.UTC)
+	if len(days) != 1 || days[0].Drinks != 1 || days[0].TotalMg != 100 {
+		t.Errorf("FinishedDays = %+v, want one day with 1 drink of 100 mg", days)
+	}
+	rolling := tracker.RollingStats(2, clock.Now(), time.UTC)
+	if rolling.Drinks != 1 || rolling.TotalMg != 100 {
+		t.Errorf("RollingStats = %d drinks, %g mg; want 1 drink, 100 mg", rolling.Drinks, rolling.TotalMg)
+	}
+}
+
+func TestOverrideOnlyDayHasNoRollup(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	if _, err := tracker.Override(50); err != nil {
+		t.Fatalf("Override: %v", err)
+	}
+	clock.Advance(48 * time.Hour)
+	if days := tracker.FinishedDays(time.UTC); len(days) != 0 {
+		t.Errorf("FinishedDays = %+v, want none without drinks", days)
+	}
+	if _, ok := tracker.RecordDay(time.UTC); ok {
+		t.Error("RecordDay found a record day without drinks")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// defaultProfile is used by requests without an X-Profile header.
+const defaultProfile = "default"
+
+// profileNamePattern restricts profile names to short slugs that are safe
+// in store keys and headers.
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+var errProfileExists = errors.New("profile already exists")
+
+// profiles holds the Tracker of every named profile, e.g. "workday" and
+// "weekend". Each profile has its own events and settings. Profiles can be
+// created but not deleted. Their names are recorded in the default
+// profile's store, so they are reopened after a restart.
+type profiles struct {
+	mu       sync.Mutex
+	trackers map[string]*Tracker
+	open     func(name string) (*Tracker, error) // Creates the tracker of a new profile
+}
+
+// newProfiles starts with the default profile backed by tracker.
+func newProfiles(tracker *Tracker, open func(name string) (*Tracker, error)) *profiles {
+	return &profiles{
+		trackers: map[string]*Tracker{defaultProfile: tracker},
+		open:     open,
+	}
+}
+
+// get returns the tracker of the named profile.
+func (p *profiles) get(name string) (*Tracker, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tracker, ok := p.trackers[name]
+	return tracker, ok
+}
+
+// validateProfileName reports whether name can be used for a new profile.
+func validateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use up to 32 lowercase letters, digits, '-' or '_'", name)
+	}
+	return nil
+}
+
+// create adds a new, empty profile and records it. The name must be valid.
+func (p *profiles) create(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.trackers[name]; ok {
+		return errProfileExists
+	}
+	tracker, err := p.open(name)
+	if err != nil {
+		return fmt.Errorf("opening profile %q: %w", name, err)
+	}
+	if err := p.trackers[defaultProfile].addProfile(name); err != nil {
+		return fmt.Errorf("recording profile %q: %w", name, err)
+	}
+	p.trackers[name] = tracker
+	fmt.Printf("Created profile %s\n", name)
+	return nil
+}
+
+// load reopens the profiles recorded by earlier runs.
+func (p *profiles) load() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names, err := p.trackers[defaultProfile].profileNames()
+	if err != nil {
+		return fmt.Errorf("reading profiles: %w", err)
+	}
+	for _, name := range names {
+		if _, ok := p.trackers[name]; ok {
+			continue
+		}
+		tracker, err := p.open(name)
+		if err != nil {
+			return fmt.Errorf("opening profile %q: %w", name, err)
+		}
+		p.trackers[name] = tracker
+	}
+	if len(names) > 0 {
+		fmt.Printf("Opened %d profiles\n", len(names))
+	}
+	return nil
+}
+
+// profileNames returns the profiles recorded in the tracker's store.
+func (t *Tracker) profileNames() ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.store.Profiles()
+}
+
+// addProfile records a created profile in the tracker's store.
+func (t *Tracker) addProfile(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.store.AddProfile(name)
+}
+
+// names lists the profiles in alphabetical order.
+func (p *profiles) names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.trackers))
+	for name := range p.trackers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// degraded reports whether any profile's store has fallen back to memory.
+func (p *profiles) degraded() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tracker := range p.trackers {
+		if tracker.StoreDegraded() {
+			return true
+		}
+	}
+	return false
+}
+
+// futureEvents sums the future-dated events found in all profiles.
+func (p *profiles) futureEvents() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	total := 0
+	for _, tracker := range p.trackers {
+		total += tracker.FutureEvents()
+	}
+	return total
+}
+
+// profileName returns the profile a request addresses.
+func profileName(r *http.Request) string {
+	if name := r.Header.Get("X-Profile"); name != "" {
+		return name
+	}
+	return defaultProfile
+}
+
+// trackerFor returns the tracker of the profile a request addresses. The
+// requireProfile middleware has already rejected unknown profiles.
+func (s *server) trackerFor(r *http.Request) *Tracker {
+	if tracker, ok := s.profiles.get(profileName(r)); ok {
+		return tracker
+	}
+	tracker, _ := s.profiles.get(defaultProfile)
+	return tracker
+}
+
+// requireProfile rejects requests for a profile that doesn't exist with 404
+// Not Found. Responses depend on the profile, so caches are told to key on
+// the header.
+func (s *server) requireProfile(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-Profile")
+		if _, ok := s.profiles.get(profileName(r)); !ok {
+			http.Error(w, fmt.Sprintf("Unknown profile %q", profileName(r)), http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestProfilesAreReopenedFromTheStore(t *testing.T) {
+	store := newMemoryStore()
+	opened := make(map[string]*memoryStore)
+	open := func(name string) (*Tracker, error) {
+		if opened[name] == nil {
+			opened[name] = newMemoryStore()
+		}
+		return NewTrackerWithStore(opened[name], systemClock{}), nil
+	}
+
+	first := newProfiles(NewTrackerWithStore(store, systemClock{}), open)
+	for _, name := range []string{"weekend", "workday"} {
+		if err := first.create(name); err != nil {
+			t.Fatalf("create(%s): %v", name, err)
+		}
+	}
+	if err := first.create("weekend"); err != errProfileExists {
+		t.Errorf("creating weekend twice: %v, want errProfileExists", err)
+	}
+	weekend, _ := first.get("weekend")
+	if err := weekend.AddDrink(80); err != nil {
+		t.Fatalf("AddDrink: %v", err)
+	}
+
+	// A restart: new trackers on the same stores
+	restarted := newProfiles(NewTrackerWithStore(store, systemClock{}), open)
+	if err := restarted.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, want := restarted.names(), []string{"default", "weekend", "workday"}; !slices.Equal(got, want) {
+		t.Errorf("names() after restart = %v, want %v", got, want)
+	}
+	weekend, ok := restarted.get("weekend")
+	if !ok || len(weekend.GetEvents()) != 1 {
+		t.Errorf("weekend after restart: found %v, want its drink", ok)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	projectionStep    = 5 * time.Minute // Sampling interval when searching the curve
+	projectionHorizon = 72 * time.Hour  // How far ahead projections look
+	maxCrossings      = 50              // Most thresholds accepted by /api/crossings
+	coverageStep      = time.Minute     // Sampling interval of band coverage
+)
+
+// Crossing is the next time the caffeine level passes a threshold.
+type Crossing struct {
+	ThresholdMg float64    `json:"thresholdMg"`
+	Time        *time.Time `json:"time"`      // nil if never crossed within the horizon
+	Direction   *string    `json:"direction"` // "up" or "down"; nil if never crossed
+}
+
+// firstTimeWhere returns the first time in [from, from+horizon] at which cond
+// holds for the level curve levelAt. The curve is sampled every
+// projectionStep and the crossing is then narrowed down by bisection to the
+// second.
+func firstTimeWhere(levelAt func(at time.Time) float64, from time.Time, horizon time.Duration, cond func(level float64) bool) (time.Time, bool) {
+	if cond(levelAt(from)) {
+		return from, true
+	}
+
+	prev := from
+	for step := projectionStep; step <= horizon; step += projectionStep {
+		at := from.Add(step)
+		if !cond(levelAt(at)) {
+			prev = at
+			continue
+		}
+
+		lo, hi := prev, at
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if cond(levelAt(mid)) {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		return hi, true
+	}
+	return time.Time{}, false
+}
+
+// objectiveLevel returns the caffeine level curve of events.
+func objectiveLevel(events []CoffeeIntakeEvent, config Config) func(at time.Time) float64 {
+	return func(at time.Time) float64 {
+		return caffeineLevelAt(events, at, config)
+	}
+}
+
+// SafeToSleepAt returns when the caffeine level will have dropped to the
+// sleep threshold for good: the first time at or after both now and the
+// peak of every logged drink where the level is at or below the threshold.
+// The level is scaled by the hourly sensitivity, so a higher multiplier
+// later in the day can push the time back.
+func (t *Tracker) SafeToSleepAt() (time.Time, bool) {
+	return safeToSleepAt(t.snapshot(), t.Config(), t.clock.Now())
+}
+
+// safeToSleepAt is SafeToSleepAt for the given events, searching from from.
+func safeToSleepAt(events []CoffeeIntakeEvent, config Config, from time.Time) (time.Time, bool) {
+	for _, event := range events {
+		// Before its peak a drink that is still being absorbed will raise the level
+		peak := event.Time.Add(time.Duration(peakHours(event, config) * float64(time.Hour)))
+		if peak.After(from) {
+			from = peak
+		}
+	}
+	effective := func(at time.Time) float64 {
+		return effectiveLevelAt(events, at, config)
+	}
+	return firstTimeWhere(effective, from, projectionHorizon, func(level float64) bool {
+		return level <= config.SleepThresholdMg
+	})
+}
+
+// Peak returns the highest caffeine level between from and from+horizon and
+// when it occurs.
+func (t *Tracker) Peak(from time.Time, horizon time.Duration) LevelPoint {
+	return peakLevel(t.snapshot(), t.Config(), from, horizon)
+}
+
+// peakLevel is Peak for the given events.
+func peakLevel(events []CoffeeIntakeEvent, config Config, from time.Time, horizon time.Duration) LevelPoint {
+	peak := LevelPoint{Time: from, Caffeine: caffeineLevelAt(events, from, config)}
+	for step := projectionStep; step <= horizon; step += projectionStep {
+		at := from.Add(step)
+		if level := caffeineLevelAt(events, at, config); level > peak.Caffeine {
+			peak = LevelPoint{Time: at, Caffeine: level}
+		}
+	}
+	return peak
+}
+
+// BandCoverage returns the fraction of [from, to] during which the caffeine
+// level is within [low, high], sampling the curve every coverageStep. It
+// returns 0 for an empty window.
+func (t *Tracker) BandCoverage(from, to time.Time, low, high float64) float64 {
+	events, config := t.snapshot(), t.Config()
+	samples, within := 0, 0
+	for at := from; !at.After(to); at = at.Add(coverageStep) {
+		samples++
+		if level := caffeineLevelAt(events, at, config); level >= low && level <= high {
+			within++
+		}
+	}
+	if samples == 0 {
+		return 0
+	}
+	return float64(within) / float64(samples)
+}
+
+// NextTimeAtOrAbove returns the first time from now on when the caffeine
+// level is at or above floor: now itself if it already is, or when a drink
+// logged for later kicks in. It returns ok=false if the level stays below
+// the floor for the whole projection horizon.
+func (t *Tracker) NextTimeAtOrAbove(floor float64) (time.Time, bool) {
+	events, config := t.snapshot(), t.Config()
+	return firstTimeWhere(objectiveLevel(events, config), t.clock.Now(), projectionHorizon, func(level float64) bool {
+		return level >= floor
+	})
+}
+
+// NextCrossings finds, for each threshold, the next time after now that the
+// caffeine level crosses it in either direction. All thresholds are
+// evaluated against the same snapshot of events.
+func (t *Tracker) NextCrossings(thresholds []float64) []Crossing {
+	events, config := t.snapshot(), t.Config()
+	now := t.clock.Now()
+	current := caffeineLevelAt(events, now, config)
+
+	crossings := make([]Crossing, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		crossing := Crossing{ThresholdMg: threshold}
+		above := current >= threshold
+		at, ok := firstTimeWhere(objectiveLevel(events, config), now, projectionHorizon, func(level float64) bool {
+			return (level >= threshold) != above
+		})
+		if ok {
+			direction := "up"
+			if above {
+				direction = "down"
+			}
+			crossing.Time, crossing.Direction = &at, &direction
+		}
+		crossings = append(crossings, crossing)
+	}
+	return crossings
+}
+
+// TimeAtLevel returns the first time at or after after when the caffeine
+// level equals target, in either direction. Once every drink has been fully
+// absorbed and all share one half-life, the level is a single exponential
+// and the time is solved exactly; otherwise the curve is searched up to the
+// projection horizon and the crossing found by bisection.
+func (t *Tracker) TimeAtLevel(target float64, after time.Time) (time.Time, bool) {
+	events, config := t.snapshot(), t.Config()
+	current := caffeineLevelAt(events, after, config)
+	if current == target {
+		return after, true
+	}
+	if halfLife, ok := decayTail(events, config, after); ok {
+		// L(t) = current * 0.5^(t / halfLife), solved for L(t) = target
+		if target <= 0 || target > current {
+			return time.Time{}, false
+		}
+		hours := halfLife * math.Log2(current/target)
+		return after.Add(time.Duration(hours * float64(time.Hour))), true
+	}
+	above := current >= target
+	return firstTimeWhere(objectiveLevel(events, config), after, projectionHorizon, func(level float64) bool {
+		return (level >= target) != above
+	})
+}
+
+// decayTail reports whether the level from at on is pure exponential decay
+// (instant absorption, exponential decay model, no drinks after at, one
+// half-life for all drinks) and returns that half-life.
+func decayTail(events []CoffeeIntakeEvent, config Config, at time.Time) (halfLife float64, ok bool) {
+	if config.AbsorptionMinutes > 0 || config.DecayModel == decayLinearTail {
+		return 0, false
+	}
+	halfLife = config.HalfLifeHours * config.halfLifeFactor()
+	for i, event := range events {
+		if event.Time.After(at) {
+			return 0, false
+		}
+		if h := config.HalfLifeFor(event.Type); i == 0 {
+			halfLife = h
+		} else if h != halfLife {
+			return 0, false
+		}
+	}
+	return halfLife, true
+}
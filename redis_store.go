@@ -0,0 +1,642 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisKey = "coffee-to-go:events" // Sorted set holding the events
+	redisTimeout    = 5 * time.Second       // Dial and per-command timeout
+
+	redisFlushTimeout = 5 * time.Minute        // Longest Flush waits for a background save
+	redisSavePoll     = 100 * time.Millisecond // Interval of background save checks
+)
+
+// redisStore keeps events in a Redis sorted set scored by timestamp, so
+// several replicas behind a load balancer share the same history. Sleep
+// entries live in a second sorted set named "<key>:sleep", deletions in
+// "<key>:tombstones", daily rollups in "<key>:rollups", what-if scenarios
+// in "<key>:scenarios" and the names of created profiles in "<key>:profiles"
+// (both scored 0), and archived days in plain keys named
+// "<key>:archive:YYYY-MM-DD".
+//
+// Most members are JSON, so finding one by ID would mean reading the whole set.
+// The sets whose members are deleted one at a time (events, trash and
+// scenarios) therefore each have a hash "<set>:index" mapping the ID, or
+// the name of a scenario, to the member.
+//
+// The URL form is redis://[:password@]host[:port][/db][?key=name].
+type redisStore struct {
+	client *redisClient
+	key    string
+}
+
+func newRedisStore(u *url.URL) (*redisStore, error) {
+	client := &redisClient{addr: u.Host}
+	if u.Port() == "" {
+		client.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		client.password = password
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+		client.db = n
+	}
+
+	key := u.Query().Get("key")
+	if key == "" {
+		key = defaultRedisKey
+	}
+
+	store := &redisStore{client: client, key: key}
+	// Fail fast on startup rather than on the first request
+	if _, err := client.do("PING"); err != nil {
+		return nil, fmt.Errorf("connecting to redis at %s: %w", client.addr, err)
+	}
+	if err := store.buildIndexes(); err != nil {
+		return nil, fmt.Errorf("indexing redis keys: %w", err)
+	}
+	return store, nil
+}
+
+func (s *redisStore) Events() ([]CoffeeIntakeEvent, error) {
+	return s.readEvents("ZRANGE", s.key, "0", "-1")
+}
+
+func (s *redisStore) EventsSince(since time.Time) ([]CoffeeIntakeEvent, error) {
+	return s.readEvents("ZRANGEBYSCORE", s.key, redisScore(since), "+inf")
+}
+
+// readEvents decodes the events returned by a sorted set range command.
+func (s *redisStore) readEvents(args ...string) ([]CoffeeIntakeEvent, error) {
+	events := make([]CoffeeIntakeEvent, 0)
+	err := s.readMembers(args, func(member []byte) error {
+		var event CoffeeIntakeEvent
+		if err := json.Unmarshal(member, &event); err != nil {
+			return fmt.Errorf("decoding event: %w", err)
+		}
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+func (s *redisStore) Add(event CoffeeIntakeEvent) error {
+	return s.addIndexed(s.key, redisScore(event.Time), event.ID, event)
+}
+
+func (s *redisStore) Remove(id string) (CoffeeIntakeEvent, bool, error) {
+	member, err := s.removeIndexed(s.key, id)
+	if err != nil || member == nil {
+		return CoffeeIntakeEvent{}, false, err
+	}
+	var event CoffeeIntakeEvent
+	if err := json.Unmarshal(member, &event); err != nil {
+		return CoffeeIntakeEvent{}, false, fmt.Errorf("decoding event: %w", err)
+	}
+	return event, true, nil
+}
+
+func (s *redisStore) TrimOldest(max int) ([]CoffeeIntakeEvent, error) {
+	// Ranks are in timestamp order, so this drops everything but the newest max
+	last := strconv.Itoa(-max - 1)
+	trimmed, err := s.readEvents("ZRANGE", s.key, "0", last)
+	if err != nil || len(trimmed) == 0 {
+		return nil, err
+	}
+	if _, err := s.client.do("ZREMRANGEBYRANK", s.key, "0", last); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(trimmed))
+	for i, event := range trimmed {
+		ids[i] = event.ID
+	}
+	return trimmed, s.unindex(s.key, ids)
+}
+
+func (s *redisStore) Tombstones() ([]Tombstone, error) {
+	tombstones := make([]Tombstone, 0)
+	err := s.readSet(s.key+":tombstones", func(member []byte) error {
+		var tombstone Tombstone
+		if err := json.Unmarshal(member, &tombstone); err != nil {
+			return fmt.Errorf("decoding tombstone: %w", err)
+		}
+		tombstones = append(tombstones, tombstone)
+		return nil
+	})
+	return tombstones, err
+}
+
+func (s *redisStore) AddTombstone(tombstone Tombstone) error {
+	return s.addToSet(s.key+":tombstones", tombstone.DeletedAt, tombstone)
+}
+
+func (s *redisStore) TrimTombstones(cutoff time.Time, max int) error {
+	key := s.key + ":tombstones"
+	if _, err := s.client.do("ZREMRANGEBYSCORE", key, "-inf", "("+redisScore(cutoff)); err != nil {
+		return err
+	}
+	_, err := s.client.do("ZREMRANGEBYRANK", key, "0", strconv.Itoa(-max-1))
+	return err
+}
+
+func (s *redisStore) Trash() ([]DeletedEvent, error) {
+	trash := make([]DeletedEvent, 0)
+	err := s.readSet(s.key+":trash", func(member []byte) error {
+		var deleted DeletedEvent
+		if err := json.Unmarshal(member, &deleted); err != nil {
+			return fmt.Errorf("decoding deleted event: %w", err)
+		}
+		trash = append(trash, deleted)
+		return nil
+	})
+	return trash, err
+}
+
+func (s *redisStore) AddTrash(deleted DeletedEvent) error {
+	return s.addIndexed(s.key+":trash", redisScore(deleted.DeletedAt), deleted.ID, deleted)
+}
+
+func (s *redisStore) RemoveTrash(id string) (DeletedEvent, bool, error) {
+	member, err := s.removeIndexed(s.key+":trash", id)
+	if err != nil || member == nil {
+		return DeletedEvent{}, false, err
+	}
+	var deleted DeletedEvent
+	if err := json.Unmarshal(member, &deleted); err != nil {
+		return DeletedEvent{}, false, fmt.Errorf("decoding deleted event: %w", err)
+	}
+	return deleted, true, nil
+}
+
+func (s *redisStore) TrimTrash(cutoff time.Time) error {
+	key := s.key + ":trash"
+	var ids []string
+	err := s.readMembers([]string{"ZRANGEBYSCORE", key, "-inf", "(" + redisScore(cutoff)}, func(member []byte) error {
+		id, err := memberID(member)
+		ids = append(ids, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.do("ZREMRANGEBYSCORE", key, "-inf", "("+redisScore(cutoff)); err != nil {
+		return err
+	}
+	return s.unindex(key, ids)
+}
+
+func (s *redisStore) SleepEntries() ([]SleepEntry, error) {
+	entries := make([]SleepEntry, 0)
+	err := s.readSet(s.key+":sleep", func(member []byte) error {
+		var entry SleepEntry
+		if err := json.Unmarshal(member, &entry); err != nil {
+			return fmt.Errorf("decoding sleep entry: %w", err)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func (s *redisStore) AddSleep(entry SleepEntry) error {
+	return s.addToSet(s.key+":sleep", entry.Time, entry)
+}
+
+func (s *redisStore) ArchiveDay(day time.Time, events []CoffeeIntakeEvent) error {
+	value, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.do("SET", s.key+":archive:"+day.Format("2006-01-02"), string(value))
+	return err
+}
+
+func (s *redisStore) Rollups() ([]DayRollup, error) {
+	rollups := make([]DayRollup, 0)
+	err := s.readSet(s.key+":rollups", func(member []byte) error {
+		var rollup DayRollup
+		if err := json.Unmarshal(member, &rollup); err != nil {
+			return fmt.Errorf("decoding rollup: %w", err)
+		}
+		rollups = append(rollups, rollup)
+		return nil
+	})
+	return rollups, err
+}
+
+func (s *redisStore) SaveRollup(rollup DayRollup) error {
+	if err := s.DeleteRollup(rollup.Day); err != nil {
+		return err
+	}
+	return s.addToSet(s.key+":rollups", rollup.Day, rollup)
+}
+
+func (s *redisStore) DeleteRollup(day time.Time) error {
+	score := redisScore(day)
+	_, err := s.client.do("ZREMRANGEBYSCORE", s.key+":rollups", score, score)
+	return err
+}
+
+func (s *redisStore) Scenarios() ([]Scenario, error) {
+	scenarios := make([]Scenario, 0)
+	err := s.readSet(s.key+":scenarios", func(member []byte) error {
+		var scenario Scenario
+		if err := json.Unmarshal(member, &scenario); err != nil {
+			return fmt.Errorf("decoding scenario: %w", err)
+		}
+		scenarios = append(scenarios, scenario)
+		return nil
+	})
+	return scenarios, err
+}
+
+func (s *redisStore) SaveScenario(scenario Scenario) error {
+	if _, err := s.DeleteScenario(scenario.Name); err != nil {
+		return err
+	}
+	return s.addIndexed(s.key+":scenarios", "0", scenario.Name, scenario)
+}
+
+func (s *redisStore) DeleteScenario(name string) (bool, error) {
+	member, err := s.removeIndexed(s.key+":scenarios", name)
+	return member != nil, err
+}
+
+func (s *redisStore) Profiles() ([]string, error) {
+	// Members with equal scores are ordered by name
+	names := make([]string, 0)
+	err := s.readSet(s.key+":profiles", func(member []byte) error {
+		names = append(names, string(member))
+		return nil
+	})
+	return names, err
+}
+
+func (s *redisStore) AddProfile(name string) error {
+	_, err := s.client.do("ZADD", s.key+":profiles", "0", name)
+	return err
+}
+
+// addIndexed stores v as JSON in the sorted set key with the given score and
+// records it under id in the set's index. The index is written before the
+// set and cleaned up after it, so it covers every member even if a command
+// fails in between.
+func (s *redisStore) addIndexed(key, score, id string, v any) error {
+	member, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.do("HSET", key+":index", id, string(member)); err != nil {
+		return err
+	}
+	_, err = s.client.do("ZADD", key, score, string(member))
+	return err
+}
+
+// removeIndexed deletes the member recorded under id from the sorted set key
+// and returns it, or nil if there is none.
+func (s *redisStore) removeIndexed(key, id string) ([]byte, error) {
+	reply, err := s.client.do("HGET", key+":index", id)
+	if err != nil {
+		return nil, err
+	}
+	member, ok := reply.(string)
+	if !ok {
+		return nil, nil
+	}
+	removed, err := s.client.do("ZREM", key, member)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.unindex(key, []string{id}); err != nil {
+		return nil, err
+	}
+	if n, _ := removed.(int64); n == 0 {
+		return nil, nil
+	}
+	return []byte(member), nil
+}
+
+// unindex drops ids from the index of the sorted set key once their members
+// are gone.
+func (s *redisStore) unindex(key string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.client.do(append([]string{"HDEL", key + ":index"}, ids...)...)
+	return err
+}
+
+// buildIndexes indexes the members of the indexed sets that are missing
+// from their index, e.g. those stored before indexes were kept.
+func (s *redisStore) buildIndexes() error {
+	for _, key := range []string{s.key, s.key + ":trash", s.key + ":scenarios"} {
+		reply, err := s.client.do("ZCARD", key)
+		if err != nil {
+			return err
+		}
+		size, _ := reply.(int64)
+		indexed, err := s.client.do("HLEN", key+":index")
+		if err != nil {
+			return err
+		}
+		if have, _ := indexed.(int64); have >= size {
+			continue
+		}
+		args := []string{"HSET", key + ":index"}
+		err = s.readSet(key, func(member []byte) error {
+			id, err := memberID(member)
+			if id != "" {
+				args = append(args, id, string(member))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			continue
+		}
+		if _, err := s.client.do(args...); err != nil {
+			return err
+		}
+		fmt.Printf("Indexed %d members of %s\n", (len(args)-2)/2, key)
+	}
+	return nil
+}
+
+// memberID returns what an indexed member is looked up by: the ID of an
+// event or deleted event, or the name of a scenario.
+func memberID(member []byte) (string, error) {
+	var keys struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(member, &keys); err != nil {
+		return "", fmt.Errorf("decoding member: %w", err)
+	}
+	if keys.ID != "" {
+		return keys.ID, nil
+	}
+	return keys.Name, nil
+}
+
+// Flush makes Redis write its dataset to disk with BGSAVE and waits for the
+// background save to finish, so other clients are served meanwhile. A save
+// or rewrite already running may predate the latest writes, so it is waited
+// out and a new save started. The snapshot covers the whole database, not
+// just this store's keys. The path of the dump file is looked up with
+// CONFIG GET and left empty where that command is not permitted.
+func (s *redisStore) Flush() (string, error) {
+	deadline := time.Now().Add(redisFlushTimeout)
+	for {
+		_, err := s.client.do("BGSAVE")
+		if err == nil {
+			break
+		}
+		var replyErr redisError
+		busy := errors.As(err, &replyErr) &&
+			(strings.Contains(string(replyErr), "in progress") || strings.Contains(string(replyErr), "child process"))
+		if !busy {
+			return "", err
+		}
+		if err := s.waitForSave(deadline); err != nil {
+			return "", err
+		}
+	}
+	if err := s.waitForSave(deadline); err != nil {
+		return "", err
+	}
+	dir, err1 := s.configValue("dir")
+	file, err2 := s.configValue("dbfilename")
+	if err1 != nil || err2 != nil {
+		return "", nil
+	}
+	return path.Join(dir, file), nil
+}
+
+// waitForSave polls INFO persistence until no background save or rewrite
+// is running, and fails if the last background save did.
+func (s *redisStore) waitForSave(deadline time.Time) error {
+	for {
+		reply, err := s.client.do("INFO", "persistence")
+		if err != nil {
+			return err
+		}
+		info, _ := reply.(string)
+		fields := make(map[string]string)
+		for _, line := range strings.Split(info, "\r\n") {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				fields[name] = value
+			}
+		}
+		if fields["rdb_bgsave_in_progress"] != "1" && fields["aof_rewrite_in_progress"] != "1" {
+			if status := fields["rdb_last_bgsave_status"]; status != "" && status != "ok" {
+				return fmt.Errorf("background save failed with status %q", status)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the background save")
+		}
+		time.Sleep(redisSavePoll)
+	}
+}
+
+// configValue reads a server setting with CONFIG GET.
+func (s *redisStore) configValue(name string) (string, error) {
+	reply, err := s.client.do("CONFIG", "GET", name)
+	if err != nil {
+		return "", err
+	}
+	pair, ok := reply.([]any)
+	if !ok || len(pair) != 2 {
+		return "", fmt.Errorf("unexpected CONFIG GET reply %v", reply)
+	}
+	value, ok := pair[1].(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected CONFIG GET reply %v", reply)
+	}
+	return value, nil
+}
+
+// readSet calls decode for every member of a sorted set in score order.
+func (s *redisStore) readSet(key string, decode func(member []byte) error) error {
+	return s.readMembers([]string{"ZRANGE", key, "0", "-1"}, decode)
+}
+
+// readMembers runs a sorted set range command and calls decode for every
+// member of the reply.
+func (s *redisStore) readMembers(args []string, decode func(member []byte) error) error {
+	reply, err := s.client.do(args...)
+	if err != nil {
+		return err
+	}
+	members, ok := reply.([]any)
+	if !ok {
+		return fmt.Errorf("unexpected %s reply %T", args[0], reply)
+	}
+	for _, m := range members {
+		member, ok := m.(string)
+		if !ok {
+			return fmt.Errorf("unexpected %s member %T", args[0], m)
+		}
+		if err := decode([]byte(member)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addToSet stores v as JSON in a sorted set, scored by its timestamp.
+func (s *redisStore) addToSet(key string, at time.Time, v any) error {
+	member, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.do("ZADD", key, redisScore(at), string(member))
+	return err
+}
+
+// redisScore is the sorted set score of a timestamp.
+func redisScore(at time.Time) string {
+	return strconv.FormatInt(at.UnixMilli(), 10)
+}
+
+// redisError is an error reply sent by the Redis server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisClient is a minimal RESP client holding a single connection, which is
+// re-established after any network error.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// do sends a command and returns its reply: a string, int64, []any, or nil.
+func (c *redisClient) do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.roundTrip(args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+func (c *redisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.rd = bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := c.roundTrip([]string{"AUTH", c.password}); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) roundTrip(args []string) (any, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.rd)
+}
+
+func readRedisReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := readRedisReply(rd)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,268 @@
+//go:build redis
+
+package main
+
+// Integration tests against a real Redis server, run with
+//
+//	go test -tags redis ./...
+//
+// REDIS_URL selects the server (default redis://localhost:6379/15). Every
+// test works under its own key, which is deleted afterwards.
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRedisStore opens a store on a key unique to the test.
+func newTestRedisStore(t *testing.T) *redisStore {
+	t.Helper()
+	store := openTestRedisStore(t, fmt.Sprintf("coffee-to-go-test:%s:%d", t.Name(), time.Now().UnixNano()))
+	t.Cleanup(func() { deleteRedisKeys(t, store) })
+	return store
+}
+
+// openTestRedisStore opens a store on key of the test server.
+func openTestRedisStore(t *testing.T, key string) *redisStore {
+	t.Helper()
+	spec := os.Getenv("REDIS_URL")
+	if spec == "" {
+		spec = "redis://localhost:6379/15"
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		t.Fatalf("invalid REDIS_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("key", key)
+	u.RawQuery = query.Encode()
+	store, err := newRedisStore(u)
+	if err != nil {
+		t.Fatalf("opening redis store: %v", err)
+	}
+	return store
+}
+
+// deleteRedisKeys removes the store's key and every key below it.
+func deleteRedisKeys(t *testing.T, store *redisStore) {
+	reply, err := store.client.do("KEYS", store.key+"*")
+	if err != nil {
+		t.Errorf("listing test keys: %v", err)
+		return
+	}
+	keys, _ := reply.([]any)
+	for _, key := range keys {
+		if _, err := store.client.do("DEL", key.(string)); err != nil {
+			t.Errorf("deleting %s: %v", key, err)
+		}
+	}
+}
+
+// indexSize returns the number of members in the index of the set key.
+func indexSize(t *testing.T, store *redisStore, key string) int64 {
+	t.Helper()
+	reply, err := store.client.do("HLEN", key+":index")
+	if err != nil {
+		t.Fatalf("HLEN: %v", err)
+	}
+	n, _ := reply.(int64)
+	return n
+}
+
+func testEvent(id string, at time.Time, amount float64) CoffeeIntakeEvent {
+	return CoffeeIntakeEvent{ID: id, Time: at, Amount: amount, ModifiedAt: at}
+}
+
+func TestRedisStoreEvents(t *testing.T) {
+	store := newTestRedisStore(t)
+	for _, event := range []CoffeeIntakeEvent{
+		testEvent("b", testStart.Add(time.Hour), 60),
+		testEvent("a", testStart, 80),
+		testEvent("c", testStart.Add(2*time.Hour), 40),
+	} {
+		if err := store.Add(event); err != nil {
+			t.Fatalf("Add(%s): %v", event.ID, err)
+		}
+	}
+
+	events, err := store.Events()
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if got := eventIDs(events); got != "a,b,c" {
+		t.Errorf("Events() = %s, want a,b,c in time order", got)
+	}
+	since, err := store.EventsSince(testStart.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("EventsSince: %v", err)
+	}
+	if got := eventIDs(since); got != "b,c" {
+		t.Errorf("EventsSince() = %s, want b,c", got)
+	}
+	if !since[0].Time.Equal(testStart.Add(time.Hour)) || since[0].Amount != 60 {
+		t.Errorf("EventsSince()[0] = %+v, want 60 mg at %v", since[0], testStart.Add(time.Hour))
+	}
+}
+
+func TestRedisStoreRemove(t *testing.T) {
+	store := newTestRedisStore(t)
+	for i, id := range []string{"a", "b", "c"} {
+		if err := store.Add(testEvent(id, testStart.Add(time.Duration(i)*time.Hour), 50)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	removed, ok, err := store.Remove("b")
+	if err != nil || !ok || removed.ID != "b" || removed.Amount != 50 {
+		t.Fatalf("Remove(b) = %+v, %v, %v; want the event", removed, ok, err)
+	}
+	if _, ok, err := store.Remove("b"); err != nil || ok {
+		t.Errorf("second Remove(b) = %v, %v; want not found", ok, err)
+	}
+	if _, ok, err := store.Remove("missing"); err != nil || ok {
+		t.Errorf("Remove(missing) = %v, %v; want not found", ok, err)
+	}
+	events, _ := store.Events()
+	if got := eventIDs(events); got != "a,c" {
+		t.Errorf("Events() after Remove = %s, want a,c", got)
+	}
+	if n := indexSize(t, store, store.key); n != 2 {
+		t.Errorf("index holds %d events, want 2", n)
+	}
+
+	trimmed, err := store.TrimOldest(1)
+	if err != nil || eventIDs(trimmed) != "a" {
+		t.Fatalf("TrimOldest(1) = %s, %v; want a", eventIDs(trimmed), err)
+	}
+	if n := indexSize(t, store, store.key); n != 1 {
+		t.Errorf("index holds %d events after trimming, want 1", n)
+	}
+}
+
+func TestRedisStoreIndexesExistingMembers(t *testing.T) {
+	store := newTestRedisStore(t)
+	// Members written without an index, as before indexes were kept
+	for i, id := range []string{"a", "b"} {
+		if err := store.addToSet(store.key, testStart.Add(time.Duration(i)*time.Hour), testEvent(id, testStart, 50)); err != nil {
+			t.Fatalf("addToSet: %v", err)
+		}
+	}
+
+	reopened := openTestRedisStore(t, store.key)
+	if n := indexSize(t, reopened, reopened.key); n != 2 {
+		t.Fatalf("index holds %d events after reopening, want 2", n)
+	}
+	if _, ok, err := reopened.Remove("a"); err != nil || !ok {
+		t.Errorf("Remove(a) = %v, %v; want removed", ok, err)
+	}
+}
+
+func TestRedisStoreTrash(t *testing.T) {
+	store := newTestRedisStore(t)
+	for i, id := range []string{"a", "b", "c"} {
+		deleted := DeletedEvent{CoffeeIntakeEvent: testEvent(id, testStart, 50), DeletedAt: testStart.Add(time.Duration(i) * time.Hour)}
+		if err := store.AddTrash(deleted); err != nil {
+			t.Fatalf("AddTrash: %v", err)
+		}
+	}
+
+	restored, ok, err := store.RemoveTrash("b")
+	if err != nil || !ok || restored.ID != "b" {
+		t.Fatalf("RemoveTrash(b) = %+v, %v, %v; want it", restored, ok, err)
+	}
+	if err := store.TrimTrash(testStart.Add(time.Hour)); err != nil {
+		t.Fatalf("TrimTrash: %v", err)
+	}
+	trash, err := store.Trash()
+	if err != nil {
+		t.Fatalf("Trash: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != "c" {
+		t.Errorf("Trash() = %+v, want only c", trash)
+	}
+	if n := indexSize(t, store, store.key+":trash"); n != 1 {
+		t.Errorf("trash index holds %d events, want 1", n)
+	}
+}
+
+func TestRedisStoreScenarios(t *testing.T) {
+	store := newTestRedisStore(t)
+	morning := Scenario{Name: "morning", Drinks: []PlannedDrink{{Time: testStart, Amount: 80}}}
+	if err := store.SaveScenario(morning); err != nil {
+		t.Fatalf("SaveScenario: %v", err)
+	}
+	morning.Drinks[0].Amount = 120
+	if err := store.SaveScenario(morning); err != nil {
+		t.Fatalf("SaveScenario again: %v", err)
+	}
+
+	scenarios, err := store.Scenarios()
+	if err != nil {
+		t.Fatalf("Scenarios: %v", err)
+	}
+	if len(scenarios) != 1 || scenarios[0].Drinks[0].Amount != 120 {
+		t.Fatalf("Scenarios() = %+v, want the replaced scenario only", scenarios)
+	}
+	if ok, err := store.DeleteScenario("morning"); err != nil || !ok {
+		t.Errorf("DeleteScenario(morning) = %v, %v; want deleted", ok, err)
+	}
+	if ok, err := store.DeleteScenario("morning"); err != nil || ok {
+		t.Errorf("second DeleteScenario(morning) = %v, %v; want not found", ok, err)
+	}
+}
+
+func TestRedisStoreRollups(t *testing.T) {
+	store := newTestRedisStore(t)
+	day := startOfDay(testStart, time.UTC)
+	for _, total := range []float64{100, 250} {
+		if err := store.SaveRollup(DayRollup{Day: day, Timezone: "UTC", Drinks: 1, TotalMg: total}); err != nil {
+			t.Fatalf("SaveRollup: %v", err)
+		}
+	}
+	rollups, err := store.Rollups()
+	if err != nil {
+		t.Fatalf("Rollups: %v", err)
+	}
+	if len(rollups) != 1 || rollups[0].TotalMg != 250 {
+		t.Errorf("Rollups() = %+v, want the replacement only", rollups)
+	}
+}
+
+func TestRedisStoreFlush(t *testing.T) {
+	store := newTestRedisStore(t)
+	if err := store.Add(testEvent("a", testStart, 50)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := store.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+}
+
+// eventIDs joins the IDs of events with commas.
+func eventIDs(events []CoffeeIntakeEvent) string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestRedisStoreProfiles(t *testing.T) {
+	store := newTestRedisStore(t)
+	for _, name := range []string{"workday", "weekend", "workday"} {
+		if err := store.AddProfile(name); err != nil {
+			t.Fatalf("AddProfile(%s): %v", name, err)
+		}
+	}
+	names, err := store.Profiles()
+	if err != nil {
+		t.Fatalf("Profiles: %v", err)
+	}
+	if strings.Join(names, ",") != "weekend,workday" {
+		t.Errorf("Profiles() = %v, want [weekend workday]", names)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DayStats holds the totals for the current stats day.
+type DayStats struct {
+	Start   time.Time `json:"start"`
+	Drinks  int       `json:"drinks"`
+	TotalMg float64   `json:"totalMg"`
+	Unit    string    `json:"unit,omitempty"` // Unit of TotalMg in responses
+}
+
+// resetBoundary returns the most recent daily reset at or before now: the
+// given local hour in loc, today or yesterday.
+func resetBoundary(now time.Time, hour int, loc *time.Location) time.Time {
+	local := now.In(loc)
+	y, m, d := local.Date()
+	boundary := time.Date(y, m, d, hour, 0, 0, 0, loc)
+	if local.Before(boundary) {
+		boundary = time.Date(y, m, d-1, hour, 0, 0, 0, loc)
+	}
+	return boundary
+}
+
+// DayStart returns when the current stats day began, i.e. the last morning reset.
+func (t *Tracker) DayStart() time.Time {
+	config := t.Config()
+	return resetBoundary(t.clock.Now(), config.ResetHour, config.Location())
+}
+
+// TotalConsumedSince sums the amounts of all drinks logged at or after since.
+func (t *Tracker) TotalConsumedSince(since time.Time) float64 {
+	total := 0.0
+	for _, event := range t.eventsSince(since) {
+		if event.Override {
+			continue
+		}
+		total += event.Amount
+	}
+	return total
+}
+
+// Today returns the totals since the last morning reset.
+func (t *Tracker) Today() DayStats {
+	start := t.DayStart()
+	return dayStats(t.eventsSince(start), start)
+}
+
+// dayStats totals the chronological drinks at or after start, leaving out
+// overrides.
+func dayStats(events []CoffeeIntakeEvent, start time.Time) DayStats {
+	stats := DayStats{Start: start}
+	for _, event := range events {
+		if !event.Time.Before(start) && !event.Override {
+			stats.Drinks++
+			stats.TotalMg += event.Amount
+		}
+	}
+	return stats
+}
+
+// Budget is the day's intake measured against the daily limit.
+type Budget struct {
+	Start       time.Time `json:"start"`
+	LimitMg     float64   `json:"limitMg"`
+	GraceMg     float64   `json:"graceMg"`
+	ConsumedMg  float64   `json:"consumedMg"`  // Everything logged since Start
+	CountedMg   float64   `json:"countedMg"`   // ConsumedMg minus the grace amount, floored at zero
+	RemainingMg float64   `json:"remainingMg"` // Negative once over the limit
+	OverLimit   bool      `json:"overLimit"`
+	Warning     string    `json:"warning,omitempty"` // Set once CountedMg reaches warnAtFraction of the limit
+	Unit        string    `json:"unit,omitempty"`    // Unit of the amounts in responses
+}
+
+// Budget compares the intake since the last morning reset to the daily
+// limit. The grace amount is taken off the total first; it is a counting
+// rule only and does not affect caffeine levels.
+func (t *Tracker) Budget() Budget {
+	start := t.DayStart()
+	return budgetFrom(t.eventsSince(start), start, t.Config())
+}
+
+// budgetFrom is Budget for the stats day starting at start, given its
+// events.
+func budgetFrom(events []CoffeeIntakeEvent, start time.Time, config Config) Budget {
+	budget := Budget{
+		Start:   start,
+		LimitMg: config.DailyLimitMg,
+		GraceMg: config.GraceMg,
+	}
+	budget.ConsumedMg = dayStats(events, start).TotalMg
+	budget.CountedMg = math.Max(budget.ConsumedMg-config.GraceMg, 0)
+	budget.RemainingMg = budget.LimitMg - budget.CountedMg
+	budget.OverLimit = budget.CountedMg > budget.LimitMg
+	if config.WarnAtFraction > 0 && budget.CountedMg >= config.WarnAtFraction*budget.LimitMg {
+		budget.Warning = limitWarning(budget.CountedMg, config)
+	}
+	return budget
+}
+
+// limitWarning describes a day's counted total in mg relative to the
+// daily limit.
+func limitWarning(counted float64, config Config) string {
+	return fmt.Sprintf("the day's total is %g mg, %.0f%% of the daily limit of %g mg",
+		config.Round(counted), counted/config.DailyLimitMg*100, config.DailyLimitMg)
+}
+
+// RunDailyReset archives each finished stats day to the store at the
+// configured reset hour until ctx is cancelled. History is never deleted;
+// the archive is a per-day copy of the events. On every wake-up it also
+// rolls up finished days that have no rollup yet.
+func (t *Tracker) RunDailyReset(ctx context.Context) {
+	dayStart := t.DayStart()
+	for {
+		if err := t.rollUpDays(); err != nil {
+			fmt.Printf("Error rolling up days: %v\n", err)
+		}
+		t.updateHalfLifeScale()
+
+		// Re-check at least hourly so changes to the reset hour or
+		// timezone take effect without a restart.
+		config := t.Config()
+		next := resetBoundary(t.clock.Now(), config.ResetHour, config.Location()).AddDate(0, 0, 1)
+		wait := min(next.Sub(t.clock.Now()), time.Hour)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if current := t.DayStart(); current.After(dayStart) {
+			if err := t.archiveDay(dayStart, current); err != nil {
+				fmt.Printf("Error archiving day %s: %v\n", dayStart.Format("2006-01-02"), err)
+			}
+			dayStart = current
+		}
+	}
+}
+
+// archiveDay copies the events in [start, end) to the store's archive.
+func (t *Tracker) archiveDay(start, end time.Time) error {
+	day := make([]CoffeeIntakeEvent, 0)
+	for _, event := range t.snapshot() {
+		if !event.Time.Before(start) && event.Time.Before(end) {
+			day = append(day, event)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.store.ArchiveDay(start, day); err != nil {
+		return err
+	}
+	fmt.Printf("Archived %d drinks for %s\n", len(day), start.Format("2006-01-02"))
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResetBoundary(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 5, 15, 3, 59, 0, 0, oslo), time.Date(2024, 5, 14, 4, 0, 0, 0, oslo)},
+		{time.Date(2024, 5, 15, 4, 0, 0, 0, oslo), time.Date(2024, 5, 15, 4, 0, 0, 0, oslo)},
+		{time.Date(2024, 5, 15, 23, 0, 0, 0, oslo), time.Date(2024, 5, 15, 4, 0, 0, 0, oslo)},
+		// 02:30 UTC is 04:30 in Oslo: already past the reset there
+		{time.Date(2024, 5, 15, 2, 30, 0, 0, time.UTC), time.Date(2024, 5, 15, 4, 0, 0, 0, oslo)},
+	}
+	for _, tt := range tests {
+		if got := resetBoundary(tt.now, 4, oslo); !got.Equal(tt.want) {
+			t.Errorf("resetBoundary(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestTodayStartsAtTheResetHour(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 5, 16, 2, 0, 0, 0, time.UTC))
+	tracker := NewTrackerWithStore(newMemoryStore(), clock)
+	config := tracker.Config()
+	config.Timezone = "UTC"
+	config.ResetHour = 4
+	if err := tracker.SetConfig(config); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+
+	// 02:00 belongs to the previous stats day, 05:00 to the new one
+	if err := tracker.AddDrink(80); err != nil {
+		t.Fatalf("AddDrink: %v", err)
+	}
+	if today := tracker.Today(); today.Drinks != 1 || today.TotalMg != 80 {
+		t.Errorf("Today() before the reset = %+v, want the 02:00 drink", today)
+	}
+
+	clock.Advance(3 * time.Hour)
+	if err := tracker.AddDrink(60); err != nil {
+		t.Fatalf("AddDrink: %v", err)
+	}
+	today := tracker.Today()
+	if !today.Start.Equal(time.Date(2024, 5, 16, 4, 0, 0, 0, time.UTC)) || today.Drinks != 1 || today.TotalMg != 60 {
+		t.Errorf("Today() after the reset = %+v, want only the 05:00 drink since 04:00", today)
+	}
+	if got := len(tracker.GetEvents()); got != 2 {
+		t.Errorf("%d events after the reset, want the history kept", got)
+	}
+}
+
+func TestArchiveDay(t *testing.T) {
+	store := newMemoryStore()
+	tracker := NewTrackerWithStore(store, newFakeClock(testStart))
+	start := time.Date(2024, 5, 15, 4, 0, 0, 0, time.UTC)
+	for _, event := range []CoffeeIntakeEvent{
+		{Time: start.Add(-time.Hour), Amount: 40},
+		{Time: start.Add(time.Hour), Amount: 80},
+		{Time: start.Add(23 * time.Hour), Amount: 60},
+		{Time: start.Add(25 * time.Hour), Amount: 20},
+	} {
+		if err := store.Add(event); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	if err := tracker.archiveDay(start, start.AddDate(0, 0, 1)); err != nil {
+		t.Fatalf("archiveDay: %v", err)
+	}
+	day := store.archive["2024-05-15"]
+	if len(day) != 2 || day[0].Amount != 80 || day[1].Amount != 60 {
+		t.Errorf("archived %+v, want the 80 and 60 mg drinks of that day", day)
+	}
+}
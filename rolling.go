@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultRollingDays = 30
+	maxRollingDays     = 366
+)
+
+// RollingStats totals the drinks of the last Days stats days, today
+// included.
+type RollingStats struct {
+	Days                int         `json:"days"`
+	Start               time.Time   `json:"start"` // Reset hour starting the first day
+	Drinks              int         `json:"drinks"`
+	TotalMg             float64     `json:"totalMg"`
+	DrinkingDays        int         `json:"drinkingDays"` // Days with at least one drink
+	AverageMgPerDay     float64     `json:"averageMgPerDay"`
+	AverageDrinksPerDay float64     `json:"averageDrinksPerDay"`
+	Daily               []DayRollup `json:"daily"`          // One bucket per day, oldest first; today's is partial
+	Unit                string      `json:"unit,omitempty"` // Unit of the amounts in responses
+}
+
+// RollingStats totals the last days stats days in loc up to now, each
+// starting at the configured ResetHour. Days are read from their rollups up to the first day of the window without
+// one; drinks from that day on are summed live. Since adding, editing or
+// deleting a drink drops the rollup of its day until the next roll-up, a
+// backdated change costs one scan from its day, never of the whole
+// history.
+func (t *Tracker) RollingStats(days int, now time.Time, loc *time.Location) RollingStats {
+	resetHour := t.Config().ResetHour
+	today := resetBoundary(now, resetHour, loc)
+	start := today.AddDate(0, 0, -(days - 1))
+
+	buckets := make([]DayRollup, days)
+	for i := range buckets {
+		buckets[i] = DayRollup{Day: start.AddDate(0, 0, i), Timezone: loc.String(), ResetHour: resetHour}
+	}
+	// Keyed by Unix time: decoded rollups carry a fixed-offset location
+	rolled := make(map[int64]DayRollup)
+	for _, rollup := range t.rollups() {
+		if rollup.cutWith(resetHour, loc) {
+			rolled[rollup.Day.Unix()] = rollup
+		}
+	}
+	live := len(buckets) - 1 // Index of the first day summed live
+	for i, bucket := range buckets[:live] {
+		rollup, ok := rolled[bucket.Day.Unix()]
+		if !ok {
+			live = i
+			break
+		}
+		buckets[i].Drinks, buckets[i].TotalMg = rollup.Drinks, rollup.TotalMg
+		buckets[i].FirstDrink, buckets[i].LastDrink = rollup.FirstDrink, rollup.LastDrink
+	}
+	for _, event := range t.eventsSince(buckets[live].Day) {
+		i := int(resetBoundary(event.Time, resetHour, loc).Sub(start).Hours()/24 + 0.5)
+		if event.Time.After(now) || event.Override || i < live || i >= len(buckets) {
+			continue
+		}
+		if buckets[i].Drinks == 0 {
+			buckets[i].FirstDrink = event.Time
+		}
+		buckets[i].Drinks++
+		buckets[i].TotalMg += event.Amount
+		buckets[i].LastDrink = event.Time
+	}
+
+	stats := RollingStats{Days: days, Start: start, Daily: buckets}
+	for _, bucket := range buckets {
+		stats.Drinks += bucket.Drinks
+		stats.TotalMg += bucket.TotalMg
+		if bucket.Drinks > 0 {
+			stats.DrinkingDays++
+		}
+	}
+	stats.AverageMgPerDay = stats.TotalMg / float64(days)
+	stats.AverageDrinksPerDay = float64(stats.Drinks) / float64(days)
+	return stats
+}
+
+// rollups returns the stored rollups. If the store can't be read the error
+// is logged and none are returned.
+func (t *Tracker) rollups() []DayRollup {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rollups, err := t.store.Rollups()
+	if err != nil {
+		fmt.Printf("Error reading rollups: %v\n", err)
+		return nil
+	}
+	return rollups
+}
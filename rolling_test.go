@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRollingStatsBackdatedAddUpdatesItsDay(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	config := tracker.Config()
+	config.Timezone = "UTC"
+	if err := tracker.SetConfig(config); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	today := resetBoundary(clock.Now(), config.ResetHour, time.UTC)
+	for day := 1; day <= 5; day++ {
+		mustAdd(t, tracker, today.AddDate(0, 0, -day).Add(9*time.Hour), 100)
+	}
+	mustAdd(t, tracker, clock.Now(), 50)
+	if err := tracker.rollUpDays(); err != nil {
+		t.Fatalf("rollUpDays: %v", err)
+	}
+	if rollups := tracker.rollups(); len(rollups) != 5 {
+		t.Fatalf("%d rollups, want one per finished day", len(rollups))
+	}
+
+	stats := tracker.RollingStats(7, clock.Now(), time.UTC)
+	if stats.Drinks != 6 || stats.TotalMg != 550 || stats.DrinkingDays != 6 || len(stats.Daily) != 7 {
+		t.Fatalf("stats = %+v, want 6 drinks, 550 mg on 6 of 7 days", stats)
+	}
+
+	// A backdated drink three days ago lands in that day's bucket
+	mustAdd(t, tracker, today.AddDate(0, 0, -3).Add(15*time.Hour), 80)
+	stats = tracker.RollingStats(7, clock.Now(), time.UTC)
+	bucket := stats.Daily[3]
+	if !bucket.Day.Equal(today.AddDate(0, 0, -3)) || bucket.Drinks != 2 || bucket.TotalMg != 180 {
+		t.Errorf("bucket of three days ago = %+v, want 2 drinks, 180 mg", bucket)
+	}
+	if stats.Drinks != 7 || stats.TotalMg != 630 {
+		t.Errorf("stats = %d drinks, %v mg, want 7 drinks, 630 mg", stats.Drinks, stats.TotalMg)
+	}
+
+	// Rolling the days up again gives the same buckets
+	if err := tracker.rollUpDays(); err != nil {
+		t.Fatalf("rollUpDays: %v", err)
+	}
+	if again := tracker.RollingStats(7, clock.Now(), time.UTC); again.Daily[3].TotalMg != 180 || again.TotalMg != 630 {
+		t.Errorf("after rolling up again: %+v", again)
+	}
+}
+
+func TestRollingStatsWindow(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+	mustAdd(t, tracker, clock.Now().AddDate(0, 0, -40), 100)
+	mustAdd(t, tracker, clock.Now(), 100)
+
+	for _, target := range []string{"/api/stats/rolling?days=0", "/api/stats/rolling?days=367", "/api/stats/rolling?days=week"} {
+		if rec := do(handler, http.MethodGet, target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want 400", target, rec.Code)
+		}
+	}
+	if rec := do(handler, http.MethodGet, "/api/stats/rolling", nil); rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/stats/rolling: status %d: %s", rec.Code, rec.Body)
+	}
+	if stats := tracker.RollingStats(defaultRollingDays, clock.Now(), time.UTC); stats.Drinks != 1 || len(stats.Daily) != 30 {
+		t.Errorf("30-day stats = %d drinks over %d days, want only today's drink", stats.Drinks, len(stats.Daily))
+	}
+	if stats := tracker.RollingStats(maxRollingDays, clock.Now(), time.UTC); stats.Drinks != 2 {
+		t.Errorf("%d-day stats = %d drinks, want both", maxRollingDays, stats.Drinks)
+	}
+}
+
+func TestRollupsFollowTheResetHour(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	config := tracker.Config()
+	config.Timezone, config.ResetHour = "UTC", 4
+	if err := tracker.SetConfig(config); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	// A nightcap at 02:00 still belongs to the previous stats day
+	yesterday := resetBoundary(clock.Now(), 4, time.UTC).AddDate(0, 0, -1)
+	mustAdd(t, tracker, yesterday.Add(5*time.Hour), 100)
+	mustAdd(t, tracker, yesterday.Add(22*time.Hour), 50)
+	if err := tracker.rollUpDays(); err != nil {
+		t.Fatalf("rollUpDays: %v", err)
+	}
+	rollups := tracker.rollups()
+	if len(rollups) != 1 || !rollups[0].Day.Equal(yesterday) || rollups[0].Drinks != 2 || rollups[0].ResetHour != 4 {
+		t.Fatalf("rollups = %+v, want one day from %v with both drinks", rollups, yesterday)
+	}
+
+	// Moving the reset hour drops the rollups; the next roll-up recuts them
+	config.ResetHour = 0
+	if err := tracker.SetConfig(config); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	if rollups := tracker.rollups(); len(rollups) != 0 {
+		t.Fatalf("rollups after moving the reset hour = %+v, want none", rollups)
+	}
+	if err := tracker.rollUpDays(); err != nil {
+		t.Fatalf("rollUpDays: %v", err)
+	}
+	rollups = tracker.rollups()
+	if len(rollups) != 1 || rollups[0].Day.Hour() != 0 || rollups[0].Drinks != 1 {
+		t.Errorf("rollups at midnight = %+v, want yesterday with one drink, the nightcap being today's", rollups)
+	}
+
+	tracker.ResetConfig()
+	if rollups := tracker.rollups(); len(rollups) != 0 {
+		t.Errorf("rollups after ResetConfig = %+v, want none", rollups)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// DayRollup holds the precomputed totals of one finished stats day, which
+// starts at the configured ResetHour. Rollups are written for every day
+// from the first drink to yesterday, including days without drinks, so a
+// gapless run of them covers the whole history before today.
+type DayRollup struct {
+	Day        time.Time `json:"day"`       // Local ResetHour starting the day
+	Timezone   string    `json:"timezone"`  // Location the day was cut in
+	ResetHour  int       `json:"resetHour"` // Hour the day was cut at
+	Drinks     int       `json:"drinks"`
+	TotalMg    float64   `json:"totalMg"`
+	FirstDrink time.Time `json:"firstDrink,omitempty"`
+	LastDrink  time.Time `json:"lastDrink,omitempty"`
+}
+
+// rollUpDays stores a rollup for every finished day that lacks one. Rollups
+// cut in another timezone or at another reset hour are replaced. The lock is held throughout so no
+// edit can slip in between reading the events and saving their rollup.
+func (t *Tracker) rollUpDays() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loc, resetHour := t.config.Location(), t.config.ResetHour
+	today := resetBoundary(t.clock.Now(), resetHour, loc)
+	rollups, err := t.store.Rollups()
+	if err != nil {
+		return err
+	}
+	// Keyed by Unix time: decoded rollups carry a fixed-offset location
+	have := make(map[int64]bool, len(rollups))
+	for _, rollup := range rollups {
+		if rollup.cutWith(resetHour, loc) {
+			have[rollup.Day.Unix()] = true
+		} else if err := t.store.DeleteRollup(rollup.Day); err != nil {
+			return err
+		}
+	}
+
+	events, err := t.store.Events()
+	if err != nil {
+		return err
+	}
+	saved := 0
+	for _, rollup := range dailyRollups(events, today, resetHour, loc) {
+		if have[rollup.Day.Unix()] {
+			continue
+		}
+		if err := t.store.SaveRollup(rollup); err != nil {
+			return err
+		}
+		saved++
+	}
+	if saved > 0 {
+		fmt.Printf("Rolled up %d days\n", saved)
+	}
+	return nil
+}
+
+// dailyRollups totals the chronological drinks per day in loc, starting
+// each day at resetHour (0 for calendar days), for every day from the
+// first drink up to but excluding today. Overrides are left out.
+func dailyRollups(events []CoffeeIntakeEvent, today time.Time, resetHour int, loc *time.Location) []DayRollup {
+	days := make(map[time.Time]DayRollup)
+	var firstDay time.Time
+	for _, event := range events {
+		if event.Override {
+			continue
+		}
+		day := resetBoundary(event.Time, resetHour, loc)
+		if firstDay.IsZero() {
+			firstDay = day
+		}
+		rollup := days[day]
+		if rollup.Drinks == 0 {
+			rollup.FirstDrink = event.Time
+		}
+		rollup.Drinks++
+		rollup.TotalMg += event.Amount
+		rollup.LastDrink = event.Time
+		days[day] = rollup
+	}
+
+	var rollups []DayRollup
+	if firstDay.IsZero() {
+		return nil
+	}
+	for day := firstDay; day.Before(today); day = day.AddDate(0, 0, 1) {
+		rollup := days[day]
+		rollup.Day, rollup.Timezone, rollup.ResetHour = day, loc.String(), resetHour
+		rollups = append(rollups, rollup)
+	}
+	return rollups
+}
+
+// FinishedDays returns the totals of every stats day in loc from the first
+// drink to yesterday, read from the rollups when a complete set exists and
+// computed from all events otherwise.
+func (t *Tracker) FinishedDays(loc *time.Location) []DayRollup {
+	resetHour := t.Config().ResetHour
+	today := resetBoundary(t.clock.Now(), resetHour, loc)
+	if rollups, ok := t.rollupsBefore(today, resetHour, loc); ok {
+		return rollups
+	}
+	return dailyRollups(t.snapshot(), today, resetHour, loc)
+}
+
+// cutWith reports whether the rollup's day starts at resetHour in loc.
+func (r DayRollup) cutWith(resetHour int, loc *time.Location) bool {
+	return r.Timezone == loc.String() && r.ResetHour == resetHour
+}
+
+// invalidateRollupLocked drops the rollup of the day containing at after a
+// drink in that day was added, edited or deleted. A drink before the first
+// rolled-up day drops every rollup, since the run no longer starts at the
+// first drink. The caller must hold t.mu.
+func (t *Tracker) invalidateRollupLocked(at time.Time) {
+	loc, resetHour := t.config.Location(), t.config.ResetHour
+	day := resetBoundary(at, resetHour, loc)
+	if !day.Before(resetBoundary(t.clock.Now(), resetHour, loc)) {
+		return
+	}
+	rollups, err := t.store.Rollups()
+	if err != nil {
+		fmt.Printf("Error reading rollups: %v\n", err)
+		return
+	}
+	if len(rollups) == 0 {
+		return
+	}
+	drop := []time.Time{day}
+	if day.Before(rollups[0].Day) {
+		drop = drop[:0]
+		for _, rollup := range rollups {
+			drop = append(drop, rollup.Day)
+		}
+	}
+	for _, d := range drop {
+		if err := t.store.DeleteRollup(d); err != nil {
+			fmt.Printf("Error invalidating rollup of %s: %v\n", d.Format("2006-01-02"), err)
+		}
+	}
+}
+
+// dropMovedRollupsLocked deletes every rollup if the stats day moved to
+// another reset hour or timezone since the settings were old. The next
+// roll-up cuts them anew. The caller must hold t.mu.
+func (t *Tracker) dropMovedRollupsLocked(old Config) {
+	if old.ResetHour == t.config.ResetHour && old.Location().String() == t.config.Location().String() {
+		return
+	}
+	rollups, err := t.store.Rollups()
+	if err != nil {
+		fmt.Printf("Error reading rollups: %v\n", err)
+		return
+	}
+	for _, rollup := range rollups {
+		if err := t.store.DeleteRollup(rollup.Day); err != nil {
+			fmt.Printf("Error dropping rollup of %s: %v\n", rollup.Day.Format("2006-01-02"), err)
+		}
+	}
+}
+
+// rollupsBefore returns the rollups covering every day from the first drink
+// up to today in loc, or ok=false if they are missing, have gaps or were
+// cut at another reset hour.
+func (t *Tracker) rollupsBefore(today time.Time, resetHour int, loc *time.Location) (rollups []DayRollup, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rollups, err := t.store.Rollups()
+	if err != nil {
+		fmt.Printf("Error reading rollups: %v\n", err)
+		return nil, false
+	}
+	if len(rollups) == 0 {
+		return nil, false
+	}
+	next := rollups[0].Day.In(loc)
+	for _, rollup := range rollups {
+		if !rollup.cutWith(resetHour, loc) || !rollup.Day.Equal(next) {
+			return nil, false
+		}
+		next = next.AddDate(0, 0, 1)
+	}
+	return rollups, next.Equal(today)
+}
+
+// eventsSince returns a copy of the events at or after since. If the store
+// can't be read the error is logged and no events are returned.
+func (t *Tracker) eventsSince(since time.Time) []CoffeeIntakeEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events, err := t.store.EventsSince(since)
+	if err != nil {
+		fmt.Printf("Error reading events: %v\n", err)
+		return []CoffeeIntakeEvent{}
+	}
+	return events
+}
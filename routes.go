@@ -0,0 +1,49 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+)
+
+// RouteInfo describes a registered API route.
+type RouteInfo struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+}
+
+// routeTable registers handlers on a mux and records each route, so the
+// list served by /api/routes is the one the mux was wired with.
+type routeTable struct {
+	mux    *http.ServeMux
+	routes []RouteInfo
+}
+
+// handle registers handler for path under each of the methods it answers
+// and records the route. The mux rejects other methods with 405 Method Not
+// Allowed.
+func (t *routeTable) handle(path string, handler http.HandlerFunc, methods ...string) {
+	for _, method := range methods {
+		t.mux.HandleFunc(method+" "+path, handler)
+	}
+	t.routes = append(t.routes, RouteInfo{Path: path, Methods: methods})
+}
+
+// handleRoutes lists the routes registered in rt, sorted by path and with
+// the base path prepended.
+func (s *server) handleRoutes(rt *routeTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes := make([]RouteInfo, len(rt.routes))
+		for i, route := range rt.routes {
+			routes[i] = RouteInfo{Path: s.basePath + route.Path, Methods: route.Methods}
+		}
+		slices.SortFunc(routes, func(a, b RouteInfo) int {
+			return cmp.Compare(a.Path, b.Path)
+		})
+		writeJSON(w, http.StatusOK, routes)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRoutesListIsEnforced(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	open := func(string) (*Tracker, error) { return NewTracker(), nil }
+	handler := newServer(newProfiles(tracker, open), serverOptions{AccessLog: io.Discard, Debug: true}).routes()
+
+	rec := do(handler, http.MethodGet, "/api/routes", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/routes: status %d", rec.Code)
+	}
+	var routes []RouteInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("decoding routes: %v", err)
+	}
+	if len(routes) == 0 {
+		t.Fatal("no routes listed")
+	}
+
+	all := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	for _, route := range routes {
+		if len(route.Methods) == 0 {
+			t.Errorf("%s lists no methods", route.Path)
+			continue
+		}
+		path := strings.NewReplacer("{id}", "x", "{name}", "x").Replace(route.Path)
+		for _, method := range all {
+			if slices.Contains(route.Methods, method) || servedByWildcard(routes, method, route.Path) {
+				continue
+			}
+			rec := do(handler, method, path, nil)
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s: status %d, want 405", method, route.Path, rec.Code)
+			}
+		}
+	}
+}
+
+// servedByWildcard reports whether another route answers method on path
+// through a wildcard, e.g. DELETE /api/events/{id} for /api/events/latest.
+func servedByWildcard(routes []RouteInfo, method, path string) bool {
+	segments := strings.Split(path, "/")
+	for _, route := range routes {
+		pattern := strings.Split(route.Path, "/")
+		if route.Path == path || len(pattern) != len(segments) || !slices.Contains(route.Methods, method) {
+			continue
+		}
+		match := true
+		for i, segment := range pattern {
+			if segment != segments[i] && !strings.HasPrefix(segment, "{") {
+				match = false
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxScenarios          = 50 // Most scenarios kept per profile
+	maxScenarioNameLength = 64
+)
+
+// errScenarioNotFound is returned when no scenario has the requested name.
+var errScenarioNotFound = errors.New("scenario not found")
+
+// errTooManyScenarios is returned when saving a new scenario would exceed
+// maxScenarios.
+var errTooManyScenarios = fmt.Errorf("at most %d scenarios can be saved", maxScenarios)
+
+// Scenario is a named plan of hypothetical drinks, kept apart from the
+// logged events so it can be forecast again later, e.g. "weekday plan".
+type Scenario struct {
+	Name   string         `json:"name"`
+	Drinks []PlannedDrink `json:"drinks"`
+}
+
+// validateScenarioName rejects names that can't be used in a URL path
+// segment or are too long.
+func validateScenarioName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxScenarioNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxScenarioNameLength)
+	}
+	if strings.Contains(name, "/") {
+		return errors.New("name must not contain /")
+	}
+	return nil
+}
+
+// Scenarios returns the saved scenarios sorted by name.
+func (t *Tracker) Scenarios() ([]Scenario, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	scenarios, err := t.store.Scenarios()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(scenarios, func(a, b Scenario) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return scenarios, nil
+}
+
+// Scenario returns the saved scenario with the given name.
+func (t *Tracker) Scenario(name string) (Scenario, error) {
+	scenarios, err := t.Scenarios()
+	if err != nil {
+		return Scenario{}, err
+	}
+	i := slices.IndexFunc(scenarios, func(s Scenario) bool { return s.Name == name })
+	if i < 0 {
+		return Scenario{}, errScenarioNotFound
+	}
+	return scenarios[i], nil
+}
+
+// SaveScenario stores scenario, replacing any saved under the same name.
+func (t *Tracker) SaveScenario(scenario Scenario) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	scenarios, err := t.store.Scenarios()
+	if err != nil {
+		return err
+	}
+	exists := slices.ContainsFunc(scenarios, func(s Scenario) bool { return s.Name == scenario.Name })
+	if !exists && len(scenarios) >= maxScenarios {
+		return errTooManyScenarios
+	}
+	if scenario.Drinks == nil {
+		scenario.Drinks = []PlannedDrink{}
+	}
+	return t.store.SaveScenario(scenario)
+}
+
+// DeleteScenario removes the scenario with the given name.
+func (t *Tracker) DeleteScenario(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	deleted, err := t.store.DeleteScenario(name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errScenarioNotFound
+	}
+	return nil
+}
+
+// ForecastScenario simulates the named scenario on top of the logged
+// history.
+func (t *Tracker) ForecastScenario(name string) (Simulation, error) {
+	scenario, err := t.Scenario(name)
+	if err != nil {
+		return Simulation{}, err
+	}
+	return t.Simulate(SimulationRequest{Drinks: scenario.Drinks, IncludeHistory: true}), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// demoDrinks is a realistic day of drinks used by SeedDemoData.
+var demoDrinks = []struct {
+	hour, minute int
+	amount       float64
+	drinkType    string
+	tags         []string
+}{
+	{8, 0, 95, "coffee", []string{"morning"}},
+	{10, 30, 63, "espresso", []string{"work"}},
+	{13, 0, 47, "tea", []string{"work"}},
+}
+
+// SeedDemoData fills an empty tracker with a day of backdated demo drinks,
+// taken from today if they are all in the past at clock's time and from
+// yesterday otherwise. It refuses to touch a tracker that already has events.
+func SeedDemoData(t *Tracker, clock Clock) error {
+	if len(t.GetEvents()) > 0 {
+		return errors.New("refusing to seed demo data into a store that already has events")
+	}
+
+	now := clock.Now().In(t.Config().Location())
+	day := now
+	last := demoDrinks[len(demoDrinks)-1]
+	if time.Date(now.Year(), now.Month(), now.Day(), last.hour, last.minute, 0, 0, now.Location()).After(now) {
+		day = now.AddDate(0, 0, -1)
+	}
+
+	events := make([]CoffeeIntakeEvent, 0, len(demoDrinks))
+	for _, drink := range demoDrinks {
+		events = append(events, CoffeeIntakeEvent{
+			Time:   time.Date(day.Year(), day.Month(), day.Day(), drink.hour, drink.minute, 0, 0, day.Location()),
+			Amount: drink.amount,
+			Type:   drink.drinkType,
+			Tags:   drink.tags,
+		})
+	}
+	_, err := t.ImportEvents(events)
+	return err
+}
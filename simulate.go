@@ -0,0 +1,71 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// maxPlannedDrinks is the most drinks accepted in a /api/simulate plan.
+const maxPlannedDrinks = 50
+
+// PlannedDrink is a drink in a simulated plan.
+type PlannedDrink struct {
+	Time   time.Time `json:"time"`
+	Amount float64   `json:"amount"`
+	Type   string    `json:"type,omitempty"`
+}
+
+// SimulationRequest is a day's plan to simulate.
+type SimulationRequest struct {
+	Drinks []PlannedDrink `json:"drinks"`
+	// IncludeHistory adds the logged drinks to the plan; otherwise the plan
+	// is simulated on its own.
+	IncludeHistory bool `json:"includeHistory"`
+	// Bedtime, if set, is checked against the sleep threshold.
+	Bedtime *time.Time `json:"bedtime"`
+}
+
+// Simulation is the outcome of a planned day.
+type Simulation struct {
+	Forecast      []ForecastPoint `json:"forecast"`      // 24 hours from the first planned drink
+	Peak          LevelPoint      `json:"peak"`          // Highest level within the forecast
+	SafeToSleepAt *time.Time      `json:"safeToSleepAt"` // nil if not within the projection horizon
+	// ViolatesSleep reports whether the level, scaled by the hourly
+	// sensitivity, is above the sleep threshold at bedtime; nil without a
+	// bedtime.
+	ViolatesSleep *bool `json:"violatesSleep"`
+}
+
+// Simulate forecasts a planned day without logging anything. The forecast
+// starts at the first planned drink, or now if the plan is empty.
+func (t *Tracker) Simulate(req SimulationRequest) Simulation {
+	config := t.Config()
+	from := t.clock.Now()
+	var events []CoffeeIntakeEvent
+	if req.IncludeHistory {
+		events = t.snapshot()
+	}
+	for i, drink := range req.Drinks {
+		if i == 0 || drink.Time.Before(from) {
+			from = drink.Time
+		}
+		events = append(events, CoffeeIntakeEvent{Time: drink.Time, Amount: drink.Amount, Type: drink.Type})
+	}
+	slices.SortStableFunc(events, func(a, b CoffeeIntakeEvent) int {
+		return cmp.Compare(a.Time.UnixNano(), b.Time.UnixNano())
+	})
+
+	sim := Simulation{
+		Forecast: forecastFrom(events, from, config),
+		Peak:     peakLevel(events, config, from, forecastPoints*forecastStep),
+	}
+	if at, ok := safeToSleepAt(events, config, from); ok {
+		sim.SafeToSleepAt = &at
+	}
+	if req.Bedtime != nil {
+		violates := effectiveLevelAt(events, *req.Bedtime, config) > config.SleepThresholdMg
+		sim.ViolatesSleep = &violates
+	}
+	return sim
+}
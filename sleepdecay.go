@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Caffeine can be metabolised at a different pace during sleep. With
+// Config.SleepHalfLifeHours set, the time between Bedtime and WakeTime
+// counts as HalfLifeHours / SleepHalfLifeHours hours per hour, so while
+// asleep HalfLifeHours is replaced by SleepHalfLifeHours and the half-lives
+// of drink types are stretched in proportion. The models in absorption.go
+// run on these metabolic hours; the absorption ramp slows down too.
+
+// sleepWindow is one night, from bedtime to wake time.
+type sleepWindow struct {
+	start, end time.Time
+}
+
+// sleepRate returns how many metabolic hours pass per hour asleep, or
+// ok=false if sleep doesn't change the pace.
+func (c Config) sleepRate() (rate float64, ok bool) {
+	if c.SleepHalfLifeHours <= 0 || c.SleepHalfLifeHours == c.HalfLifeHours || c.WakeTime == "" || c.Bedtime == "" {
+		return 1, false
+	}
+	return c.HalfLifeHours / c.SleepHalfLifeHours, true
+}
+
+// sleepWindows returns the nights overlapping from to to in the configured
+// timezone, clipped to that range and in order. A night starts at Bedtime
+// on one day and ends at WakeTime on the next, or the same day if the
+// bedtime is after midnight.
+func sleepWindows(from, to time.Time, config Config) []sleepWindow {
+	loc := config.Location()
+	var windows []sleepWindow
+	for day := startOfDay(from, loc).AddDate(0, 0, -1); !day.After(to); day = day.AddDate(0, 0, 1) {
+		// Validate guarantees both times parse
+		start, _ := clockTimeOn(config.Bedtime, day, loc)
+		end, _ := clockTimeOn(config.WakeTime, day, loc)
+		if !end.After(start) {
+			end, _ = clockTimeOn(config.WakeTime, day.AddDate(0, 0, 1), loc)
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			windows = append(windows, sleepWindow{start, end})
+		}
+	}
+	return windows
+}
+
+// asleepHours returns how many of the hours from from to to fall in a
+// night. Whole days count one night each, ignoring daylight saving shifts,
+// so long spans cost no more than short ones.
+func asleepHours(from, to time.Time, config Config) float64 {
+	asleep := 0.0
+	if days := int(to.Sub(from) / (24 * time.Hour)); days > 0 {
+		bed, _ := time.Parse("15:04", config.Bedtime)
+		wake, _ := time.Parse("15:04", config.WakeTime)
+		night := wake.Sub(bed)
+		if night <= 0 {
+			night += 24 * time.Hour
+		}
+		asleep = float64(days) * night.Hours()
+		from = from.AddDate(0, 0, days)
+	}
+	for _, w := range sleepWindows(from, to, config) {
+		asleep += w.end.Sub(w.start).Hours()
+	}
+	return asleep
+}
+
+// metabolicHours converts the hours since event was logged into metabolic
+// hours, slowing down or speeding up the part spent asleep.
+func metabolicHours(event CoffeeIntakeEvent, hours float64, config Config) float64 {
+	rate, ok := config.sleepRate()
+	if !ok || hours <= 0 {
+		return hours
+	}
+	asleep := asleepHours(event.Time, event.Time.Add(time.Duration(hours*float64(time.Hour))), config)
+	return hours + asleep*(rate-1)
+}
+
+// sleepAdjustedExposure integrates the level of event from a to b hours
+// after it was logged, in mg·h. Metabolic time runs at a constant pace
+// between bedtimes and wake times, so each stretch adds the exposure over
+// its metabolic hours divided by that pace.
+func sleepAdjustedExposure(event CoffeeIntakeEvent, a, b float64, config Config) float64 {
+	rate, ok := config.sleepRate()
+	if !ok {
+		return metabolicExposure(event, a, b, config)
+	}
+	at := func(hours float64) time.Time {
+		return event.Time.Add(time.Duration(hours * float64(time.Hour)))
+	}
+	exposure := 0.0
+	hours, metabolic := a, metabolicHours(event, a, config)
+	stretch := func(until, pace float64) {
+		if until <= hours {
+			return
+		}
+		next := metabolic + (until-hours)*pace
+		exposure += metabolicExposure(event, metabolic, next, config) / pace
+		hours, metabolic = until, next
+	}
+	for _, w := range sleepWindows(at(a), at(b), config) {
+		stretch(math.Min(w.start.Sub(event.Time).Hours(), b), 1)
+		stretch(math.Min(w.end.Sub(event.Time).Hours(), b), rate)
+	}
+	stretch(b, 1)
+	return exposure
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func sleepConfig(bedtime, wakeTime string) Config {
+	config := DefaultConfig()
+	config.Timezone = "UTC"
+	config.HalfLifeHours = 5
+	config.SleepHalfLifeHours = 10
+	config.Bedtime, config.WakeTime = bedtime, wakeTime
+	return config
+}
+
+func TestDecayAcrossASleepBoundary(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	if err := tracker.SetConfig(sleepConfig("23:00", "07:00")); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	drink := time.Date(2024, 5, 15, 21, 0, 0, 0, time.UTC)
+	mustAdd(t, tracker, drink, 100)
+
+	tests := []struct {
+		at       time.Time
+		exponent float64 // Half-lives elapsed
+	}{
+		{drink.Add(2 * time.Hour), 2.0 / 5},                 // 23:00, still awake
+		{drink.Add(6 * time.Hour), 2.0/5 + 4.0/10},          // 03:00, four hours asleep
+		{drink.Add(10 * time.Hour), 2.0/5 + 8.0/10},         // 07:00, waking up
+		{drink.Add(12 * time.Hour), 2.0/5 + 8.0/10 + 2.0/5}, // 09:00
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.at.Sub(clock.Now()))
+		want := 100 * math.Pow(0.5, tt.exponent)
+		if got := tracker.CalculateCaffeineLevelAt(tt.at); math.Abs(got-want) > 1e-6 {
+			t.Errorf("level at %s = %v, want %v", tt.at.Format("15:04"), got, want)
+		}
+	}
+}
+
+func TestEqualHalfLivesKeepTheAwakeDecay(t *testing.T) {
+	event := CoffeeIntakeEvent{Time: time.Date(2024, 5, 15, 21, 0, 0, 0, time.UTC), Amount: 100}
+	for _, sleepHalfLife := range []float64{0, 5} {
+		config := sleepConfig("23:00", "07:00")
+		config.SleepHalfLifeHours = sleepHalfLife
+		if got, want := eventLevel(event, 12, config), 100*math.Pow(0.5, 12.0/5); math.Abs(got-want) > 1e-9 {
+			t.Errorf("sleepHalfLifeHours %g: level %v, want %v", sleepHalfLife, got, want)
+		}
+	}
+}
+
+func TestAsleepHours(t *testing.T) {
+	from := time.Date(2024, 5, 15, 21, 0, 0, 0, time.UTC)
+	tests := []struct {
+		bedtime, wakeTime string
+		hours, want       float64
+	}{
+		{"23:00", "07:00", 1, 0},
+		{"23:00", "07:00", 12, 8},
+		{"23:00", "07:00", 3*24 + 5, 3*8 + 3},
+		{"01:00", "09:00", 12, 8},
+		{"01:00", "09:00", 2*24 + 6, 2*8 + 2},
+	}
+	for _, tt := range tests {
+		config := sleepConfig(tt.bedtime, tt.wakeTime)
+		to := from.Add(time.Duration(tt.hours * float64(time.Hour)))
+		if got := asleepHours(from, to, config); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s-%s over %g h: %v hours asleep, want %v", tt.bedtime, tt.wakeTime, tt.hours, got, tt.want)
+		}
+	}
+}
+
+func TestSleepAdjustedExposureMatchesLevel(t *testing.T) {
+	event := CoffeeIntakeEvent{Time: time.Date(2024, 5, 15, 21, 0, 0, 0, time.UTC), Amount: 100}
+	for _, absorption := range []float64{0, 30} {
+		config := sleepConfig("23:00", "07:00")
+		config.AbsorptionMinutes = absorption
+		for _, span := range [][2]float64{{0, 24}, {1, 13}, {3, 5}} {
+			const steps = 20000
+			width := (span[1] - span[0]) / steps
+			numeric := 0.0
+			for i := range steps {
+				numeric += eventLevel(event, span[0]+(float64(i)+0.5)*width, config) * width
+			}
+			if got := eventExposure(event, span[0], span[1], config); math.Abs(got-numeric) > 1e-3*numeric {
+				t.Errorf("absorption %g: exposure over %v = %v, want about %v", absorption, span, got, numeric)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// smoothFactor is how many output points smoothForecast produces per
+// forecast interval.
+const smoothFactor = 6
+
+// smoothForecast densifies a forecast by inserting smoothFactor-1 points
+// between each pair of samples, interpolated with a monotone cubic
+// (Fritsch-Carlson) spline. Monotonicity means the curve never overshoots
+// the samples, so it never dips below zero or invents peaks. Drink markers
+// stay on the original samples.
+func smoothForecast(points []ForecastPoint) []ForecastPoint {
+	n := len(points)
+	if n < 3 {
+		return points
+	}
+
+	// Interval widths (hours) and secant slopes
+	h := make([]float64, n-1)
+	d := make([]float64, n-1)
+	for k := 0; k < n-1; k++ {
+		h[k] = points[k+1].Time.Sub(points[k].Time).Hours()
+		d[k] = (points[k+1].Caffeine - points[k].Caffeine) / h[k]
+	}
+
+	// Initial tangents: average of neighbouring secants, zero at extrema
+	m := make([]float64, n)
+	m[0], m[n-1] = d[0], d[n-2]
+	for k := 1; k < n-1; k++ {
+		if d[k-1]*d[k] > 0 {
+			m[k] = (d[k-1] + d[k]) / 2
+		}
+	}
+
+	// Limit tangents so each segment stays monotone
+	for k := 0; k < n-1; k++ {
+		if d[k] == 0 {
+			m[k], m[k+1] = 0, 0
+			continue
+		}
+		a, b := m[k]/d[k], m[k+1]/d[k]
+		if s := a*a + b*b; s > 9 {
+			tau := 3 / math.Sqrt(s)
+			m[k], m[k+1] = tau*a*d[k], tau*b*d[k]
+		}
+	}
+
+	smoothed := make([]ForecastPoint, 0, (n-1)*smoothFactor+1)
+	for k := 0; k < n-1; k++ {
+		smoothed = append(smoothed, points[k])
+		span := points[k+1].Time.Sub(points[k].Time)
+		for i := 1; i < smoothFactor; i++ {
+			t := float64(i) / smoothFactor
+			h00 := 2*t*t*t - 3*t*t + 1
+			h10 := t*t*t - 2*t*t + t
+			h01 := -2*t*t*t + 3*t*t
+			h11 := t*t*t - t*t
+			smoothed = append(smoothed, ForecastPoint{
+				Time: points[k].Time.Add(time.Duration(float64(span) * t)),
+				Caffeine: h00*points[k].Caffeine + h10*h[k]*m[k] +
+					h01*points[k+1].Caffeine + h11*h[k]*m[k+1],
+			})
+		}
+	}
+	return append(smoothed, points[n-1])
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const maxSnooze = 24 * time.Hour // Longest a snooze may last
+
+// Snooze suppresses warnings for the given duration and returns when the
+// snooze ends. A new snooze replaces any current one.
+func (t *Tracker) Snooze(d time.Duration) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until := t.clock.Now().Add(d)
+	t.config.SnoozeUntil = &until
+	t.version++
+	t.notifier.Notify()
+	fmt.Printf("Warnings snoozed until %s\n", until.Format("15:04:05"))
+	return until
+}
+
+// ClearSnooze ends a snooze early.
+func (t *Tracker) ClearSnooze() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.config.SnoozeUntil == nil {
+		return
+	}
+	t.config.SnoozeUntil = nil
+	t.version++
+	t.notifier.Notify()
+}
+
+// SnoozedUntil returns when the current snooze ends, or ok=false if
+// warnings are not snoozed.
+func (t *Tracker) SnoozedUntil() (until time.Time, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expireSnoozeLocked()
+	if t.config.SnoozeUntil == nil {
+		return time.Time{}, false
+	}
+	return *t.config.SnoozeUntil, true
+}
+
+// expireSnoozeLocked clears a snooze that has run out. The caller must hold
+// t.mu.
+func (t *Tracker) expireSnoozeLocked() {
+	if t.config.SnoozeUntil != nil && !t.clock.Now().Before(*t.config.SnoozeUntil) {
+		t.config.SnoozeUntil = nil
+		t.version++
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	staticMaxAge     = 24 * 60 * 60 // Seconds browsers may reuse a static asset without asking
+	htmlCacheControl = "no-cache"   // HTML is always revalidated, so UI updates show up on the next load
+)
+
+// staticFiles serves the files in dir with caching headers. Each file's ETag
+// is a hash of its content, computed once when the handler is created, so
+// unchanged files are answered with 304 Not Modified. Files changed on disk
+// afterwards keep their old ETag until the server restarts.
+func staticFiles(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	etags, err := hashFiles(os.DirFS(dir))
+	if err != nil {
+		fmt.Printf("Error hashing static files, serving them without ETags: %v\n", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		if etag, ok := etags[name]; ok {
+			if path.Ext(name) == ".html" {
+				w.Header().Set("Cache-Control", htmlCacheControl)
+			} else {
+				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+			}
+			if notModified(w, r, etag) {
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// hashFiles returns a strong ETag for every file in fsys, keyed by its URL
+// path.
+func hashFiles(fsys fs.FS) (map[string]string, error) {
+	etags := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		etags["/"+name] = `"` + hex.EncodeToString(sum[:8]) + `"`
+		return nil
+	})
+	return etags, err
+}
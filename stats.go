@@ -0,0 +1,247 @@
+package main
+
+import (
+	"time"
+)
+
+// Summary holds lifetime statistics about logged drinks.
+type Summary struct {
+	TotalDrinks         int        `json:"totalDrinks"`
+	TotalMg             float64    `json:"totalMg"`
+	FirstDrink          *time.Time `json:"firstDrink,omitempty"`
+	LastDrink           *time.Time `json:"lastDrink,omitempty"`
+	CurrentStreakDays   int        `json:"currentStreakDays"`
+	AverageDrinksPerDay float64    `json:"averageDrinksPerDay"`
+	Unit                string     `json:"unit,omitempty"` // Unit of TotalMg in responses
+}
+
+// Summary computes lifetime statistics as of now, using stats days in loc
+// that start at the configured ResetHour. Finished days are read from their
+// precomputed rollups when a complete set exists for loc, so only today's
+// drinks are summed live; otherwise the whole history is.
+func (t *Tracker) Summary(now time.Time, loc *time.Location) Summary {
+	resetHour := t.Config().ResetHour
+	today := resetBoundary(now, resetHour, loc)
+	if rollups, ok := t.rollupsBefore(today, resetHour, loc); ok {
+		return summarizeRollups(rollups, t.eventsSince(today), now, resetHour, loc)
+	}
+	return summarizeRollups(nil, t.snapshot(), now, resetHour, loc)
+}
+
+// summarize computes lifetime statistics over a snapshot of events, using
+// calendar days in loc.
+func summarize(events []CoffeeIntakeEvent, now time.Time, loc *time.Location) Summary {
+	return summarizeRollups(nil, events, now, 0, loc)
+}
+
+// summarizeRollups computes lifetime statistics from rollups of finished
+// days plus the events logged after them, with days starting at resetHour
+// in loc.
+func summarizeRollups(rollups []DayRollup, events []CoffeeIntakeEvent, now time.Time, resetHour int, loc *time.Location) Summary {
+	var summary Summary
+	var first, last time.Time
+	days := make(map[time.Time]bool)
+	count := func(drinks int, mg float64, from, to time.Time) {
+		if drinks == 0 {
+			return
+		}
+		if summary.TotalDrinks == 0 || from.Before(first) {
+			first = from
+		}
+		if summary.TotalDrinks == 0 || to.After(last) {
+			last = to
+		}
+		summary.TotalDrinks += drinks
+		summary.TotalMg += mg
+		days[resetBoundary(from, resetHour, loc)] = true
+	}
+	for _, rollup := range rollups {
+		count(rollup.Drinks, rollup.TotalMg, rollup.FirstDrink, rollup.LastDrink)
+	}
+	for _, event := range events {
+		if !event.Override {
+			count(1, event.Amount, event.Time, event.Time)
+		}
+	}
+	if summary.TotalDrinks == 0 {
+		return summary
+	}
+	summary.FirstDrink = &first
+	summary.LastDrink = &last
+
+	// The streak is still alive if the last drink was yesterday and
+	// nothing has been logged yet today.
+	day := resetBoundary(now, resetHour, loc)
+	if !days[day] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for days[day] {
+		summary.CurrentStreakDays++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	span := daysBetween(resetBoundary(first, resetHour, loc), resetBoundary(now, resetHour, loc)) + 1
+	if span < 1 {
+		span = 1
+	}
+	summary.AverageDrinksPerDay = float64(summary.TotalDrinks) / float64(span)
+
+	return summary
+}
+
+// startOfDay returns local midnight of the calendar day containing t.
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// daysBetween counts whole calendar days from one local midnight to another,
+// ignoring DST shifts in the length of individual days.
+func daysBetween(from, to time.Time) int {
+	fy, fm, fd := from.Date()
+	ty, tm, td := to.Date()
+	a := time.Date(fy, fm, fd, 0, 0, 0, 0, time.UTC)
+	b := time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// RecordDay holds the calendar days with the most caffeine on record.
+type RecordDay struct {
+	TotalDay        time.Time `json:"totalDay"`        // Day with the highest total intake
+	TotalMg         float64   `json:"totalMg"`         // Intake on TotalDay
+	ExposureDay     time.Time `json:"exposureDay"`     // Day with the highest integrated level
+	ExposureMgHours float64   `json:"exposureMgHours"` // Area under the level curve on ExposureDay
+	Unit            string    `json:"unit,omitempty"`  // Unit of TotalMg and ExposureMgHours in responses
+}
+
+// RecordDay finds the calendar days in tz with the highest total intake and
+// the highest integrated caffeine exposure. Only days with at least one drink
+// are candidates; today's exposure counts up to now. It returns ok=false if
+// no drinks are logged.
+func (t *Tracker) RecordDay(tz *time.Location) (RecordDay, bool) {
+	return recordDay(t.snapshot(), t.Config(), t.clock.Now(), tz)
+}
+
+// recordDay is RecordDay for a snapshot of events.
+func recordDay(events []CoffeeIntakeEvent, config Config, now time.Time, tz *time.Location) (RecordDay, bool) {
+	totals := make(map[time.Time]float64)
+	for _, event := range events {
+		if !event.Override {
+			totals[startOfDay(event.Time, tz)] += event.Amount
+		}
+	}
+	if len(totals) == 0 {
+		return RecordDay{}, false
+	}
+
+	var record RecordDay
+	first := true
+	for day, total := range totals {
+		end := day.AddDate(0, 0, 1)
+		if end.After(now) {
+			end = now
+		}
+		exposure := exposureBetween(events, day, end, config)
+		if first || total > record.TotalMg || (total == record.TotalMg && day.Before(record.TotalDay)) {
+			record.TotalDay, record.TotalMg = day, total
+		}
+		if first || exposure > record.ExposureMgHours || (exposure == record.ExposureMgHours && day.Before(record.ExposureDay)) {
+			record.ExposureDay, record.ExposureMgHours = day, exposure
+		}
+		first = false
+	}
+	return record, true
+}
+
+// exposureBetween integrates the caffeine level over [from, to] in mg·h,
+// using the closed form of each drink's level curve.
+func exposureBetween(events []CoffeeIntakeEvent, from, to time.Time, config Config) float64 {
+	total := 0.0
+	for _, event := range events {
+		if !event.Time.Before(to) {
+			continue
+		}
+		start := from
+		if event.Time.After(start) {
+			start = event.Time
+		}
+		a := start.Sub(event.Time).Hours()
+		b := to.Sub(event.Time).Hours()
+		total += eventExposure(event, a, b, config)
+	}
+	return total
+}
+
+// WeekTotals holds the drinks logged in [Start, End).
+type WeekTotals struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Drinks  int       `json:"drinks"`
+	TotalMg float64   `json:"totalMg"`
+}
+
+// WeeklyComparison compares the current week so far to last week. Current
+// is week-to-date, so it is compared to the same elapsed portion of last
+// week; PreviousFullWeek is included for context.
+type WeeklyComparison struct {
+	Current          WeekTotals `json:"current"`
+	Previous         WeekTotals `json:"previous"` // Last week up to the same point in the week
+	PreviousFullWeek WeekTotals `json:"previousFullWeek"`
+	DrinksChangePct  *float64   `json:"drinksChangePct"` // nil if Previous has no drinks
+	MgChangePct      *float64   `json:"mgChangePct"`     // nil if Previous has no intake
+	Unit             string     `json:"unit,omitempty"`  // Unit of the totals in responses
+}
+
+// WeeklyComparison compares this week to date with last week, using
+// calendar days in loc and the configured first day of the week.
+func (t *Tracker) WeeklyComparison(loc *time.Location) WeeklyComparison {
+	config := t.Config()
+	now := t.clock.Now()
+	prevStart := startOfWeek(now, weekdays[config.WeekStart], loc).AddDate(0, 0, -7)
+	return weeklyComparison(t.eventsSince(prevStart), config, now, loc)
+}
+
+// weeklyComparison is WeeklyComparison given at least the events since the
+// start of last week.
+func weeklyComparison(events []CoffeeIntakeEvent, config Config, now time.Time, loc *time.Location) WeeklyComparison {
+	start := startOfWeek(now, weekdays[config.WeekStart], loc)
+	prevStart := start.AddDate(0, 0, -7)
+	cmp := WeeklyComparison{
+		Current:          weekTotals(events, start, now),
+		Previous:         weekTotals(events, prevStart, prevStart.Add(now.Sub(start))),
+		PreviousFullWeek: weekTotals(events, prevStart, start),
+	}
+	cmp.DrinksChangePct = percentChange(float64(cmp.Previous.Drinks), float64(cmp.Current.Drinks))
+	cmp.MgChangePct = percentChange(cmp.Previous.TotalMg, cmp.Current.TotalMg)
+	return cmp
+}
+
+// startOfWeek returns local midnight of the most recent first day of the
+// week at or before t.
+func startOfWeek(t time.Time, first time.Weekday, loc *time.Location) time.Time {
+	day := startOfDay(t, loc)
+	back := (int(day.Weekday()) - int(first) + 7) % 7
+	return day.AddDate(0, 0, -back)
+}
+
+// weekTotals counts the drinks logged in [start, end).
+func weekTotals(events []CoffeeIntakeEvent, start, end time.Time) WeekTotals {
+	totals := WeekTotals{Start: start, End: end}
+	for _, event := range events {
+		if !event.Time.Before(start) && event.Time.Before(end) && !event.Override {
+			totals.Drinks++
+			totals.TotalMg += event.Amount
+		}
+	}
+	return totals
+}
+
+// percentChange returns the change from before to after in percent, or nil
+// if before is zero.
+func percentChange(before, after float64) *float64 {
+	if before == 0 {
+		return nil
+	}
+	pct := (after - before) / before * 100
+	return &pct
+}
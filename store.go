@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Store persists coffee intake events. The Tracker serializes all calls
+// under its own mutex, so implementations only need to be safe for use
+// by one goroutine at a time.
+type Store interface {
+	// Events returns all stored events in chronological order.
+	Events() ([]CoffeeIntakeEvent, error)
+	// EventsSince returns the events at or after since in chronological order.
+	EventsSince(since time.Time) ([]CoffeeIntakeEvent, error)
+	// Add stores a new event.
+	Add(event CoffeeIntakeEvent) error
+	// Remove deletes the event with the given ID and returns it, reporting
+	// whether it existed.
+	Remove(id string) (CoffeeIntakeEvent, bool, error)
+	// TrimOldest deletes the oldest events so that at most max remain, and
+	// returns the deleted events.
+	TrimOldest(max int) ([]CoffeeIntakeEvent, error)
+	// Tombstones returns the records of deleted events, oldest first.
+	Tombstones() ([]Tombstone, error)
+	// AddTombstone records the deletion of an event.
+	AddTombstone(tombstone Tombstone) error
+	// TrimTombstones forgets deletions before cutoff and then all but the
+	// newest max.
+	TrimTombstones(cutoff time.Time, max int) error
+	// Trash returns the deleted events that can still be restored, oldest
+	// deletion first.
+	Trash() ([]DeletedEvent, error)
+	// AddTrash keeps a deleted event so it can be restored.
+	AddTrash(deleted DeletedEvent) error
+	// RemoveTrash takes the deleted event with the given ID out of the
+	// trash and returns it, reporting whether it was there.
+	RemoveTrash(id string) (DeletedEvent, bool, error)
+	// TrimTrash purges the events deleted before cutoff.
+	TrimTrash(cutoff time.Time) error
+	// SleepEntries returns all logged nights of sleep in chronological order.
+	SleepEntries() ([]SleepEntry, error)
+	// AddSleep stores a new night of sleep.
+	AddSleep(entry SleepEntry) error
+	// ArchiveDay saves a copy of the events of the stats day starting at day.
+	ArchiveDay(day time.Time, events []CoffeeIntakeEvent) error
+	// Rollups returns the stored daily rollups in chronological order.
+	Rollups() ([]DayRollup, error)
+	// SaveRollup stores a rollup, replacing any other for the same day.
+	SaveRollup(rollup DayRollup) error
+	// DeleteRollup removes the rollup of the day starting at day, if any.
+	DeleteRollup(day time.Time) error
+	// Scenarios returns the saved what-if scenarios.
+	Scenarios() ([]Scenario, error)
+	// SaveScenario stores a scenario, replacing any other with its name.
+	SaveScenario(scenario Scenario) error
+	// DeleteScenario removes the scenario with the given name, reporting
+	// whether it existed.
+	DeleteScenario(name string) (bool, error)
+	// Profiles returns the names of the profiles created besides the
+	// default one, in alphabetical order.
+	Profiles() ([]string, error)
+	// AddProfile records the creation of a profile.
+	AddProfile(name string) error
+}
+
+// openStore creates the store described by spec: "memory" (the default) or
+// a redis:// URL. Persistent stores fall back to memory while they are
+// unavailable; run retryStore to have them recover.
+func openStore(spec string) (Store, error) {
+	if spec == "" || spec == "memory" {
+		return newMemoryStore(), nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store %q: %w", spec, err)
+	}
+	switch u.Scheme {
+	case "redis":
+		redis, err := newRedisStore(u)
+		if err != nil {
+			return nil, err
+		}
+		return newFallbackStore(redis)
+	default:
+		return nil, fmt.Errorf("unsupported store %q", spec)
+	}
+}
+
+// openProfileStore creates the store of a named profile other than the
+// default one: a fresh memory store, or for Redis the same server with the
+// keys prefixed "<key>:profile:<name>".
+func openProfileStore(spec, profile string) (Store, error) {
+	if spec == "" || spec == "memory" {
+		return newMemoryStore(), nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store %q: %w", spec, err)
+	}
+	query := u.Query()
+	key := query.Get("key")
+	if key == "" {
+		key = defaultRedisKey
+	}
+	query.Set("key", key+":profile:"+profile)
+	u.RawQuery = query.Encode()
+	return openStore(u.String())
+}
+
+// memoryStore keeps events in a slice; they are lost when the process exits.
+type memoryStore struct {
+	events     []CoffeeIntakeEvent
+	tombstones []Tombstone
+	trash      []DeletedEvent
+	sleep      []SleepEntry
+	archive    map[string][]CoffeeIntakeEvent
+	rollups    []DayRollup
+	scenarios  []Scenario
+	profiles   []string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		events:  make([]CoffeeIntakeEvent, 0),
+		archive: make(map[string][]CoffeeIntakeEvent),
+	}
+}
+
+func (m *memoryStore) Events() ([]CoffeeIntakeEvent, error) {
+	events := make([]CoffeeIntakeEvent, len(m.events))
+	copy(events, m.events)
+	return events, nil
+}
+
+func (m *memoryStore) EventsSince(since time.Time) ([]CoffeeIntakeEvent, error) {
+	i := sort.Search(len(m.events), func(i int) bool {
+		return !m.events[i].Time.Before(since)
+	})
+	return slices.Clone(m.events[i:]), nil
+}
+
+// Add inserts the event in time order, keeping the slice chronological even
+// for backdated events.
+func (m *memoryStore) Add(event CoffeeIntakeEvent) error {
+	i := sort.Search(len(m.events), func(i int) bool {
+		return m.events[i].Time.After(event.Time)
+	})
+	m.events = append(m.events, CoffeeIntakeEvent{})
+	copy(m.events[i+1:], m.events[i:])
+	m.events[i] = event
+	return nil
+}
+
+func (m *memoryStore) Remove(id string) (CoffeeIntakeEvent, bool, error) {
+	i := slices.IndexFunc(m.events, func(event CoffeeIntakeEvent) bool {
+		return event.ID == id
+	})
+	if i < 0 {
+		return CoffeeIntakeEvent{}, false, nil
+	}
+	removed := m.events[i]
+	m.events = slices.Delete(m.events, i, i+1)
+	return removed, true, nil
+}
+
+func (m *memoryStore) TrimOldest(max int) ([]CoffeeIntakeEvent, error) {
+	excess := len(m.events) - max
+	if excess <= 0 {
+		return nil, nil
+	}
+	trimmed := slices.Clone(m.events[:excess])
+	m.events = slices.Delete(m.events, 0, excess)
+	return trimmed, nil
+}
+
+func (m *memoryStore) Tombstones() ([]Tombstone, error) {
+	return slices.Clone(m.tombstones), nil
+}
+
+func (m *memoryStore) AddTombstone(tombstone Tombstone) error {
+	m.tombstones = append(m.tombstones, tombstone)
+	return nil
+}
+
+func (m *memoryStore) TrimTombstones(cutoff time.Time, max int) error {
+	m.tombstones = slices.DeleteFunc(m.tombstones, func(tombstone Tombstone) bool {
+		return tombstone.DeletedAt.Before(cutoff)
+	})
+	if excess := len(m.tombstones) - max; excess > 0 {
+		m.tombstones = slices.Delete(m.tombstones, 0, excess)
+	}
+	return nil
+}
+
+func (m *memoryStore) Trash() ([]DeletedEvent, error) {
+	return slices.Clone(m.trash), nil
+}
+
+func (m *memoryStore) AddTrash(deleted DeletedEvent) error {
+	m.trash = append(m.trash, deleted)
+	return nil
+}
+
+func (m *memoryStore) RemoveTrash(id string) (DeletedEvent, bool, error) {
+	i := slices.IndexFunc(m.trash, func(deleted DeletedEvent) bool {
+		return deleted.ID == id
+	})
+	if i < 0 {
+		return DeletedEvent{}, false, nil
+	}
+	removed := m.trash[i]
+	m.trash = slices.Delete(m.trash, i, i+1)
+	return removed, true, nil
+}
+
+func (m *memoryStore) TrimTrash(cutoff time.Time) error {
+	m.trash = slices.DeleteFunc(m.trash, func(deleted DeletedEvent) bool {
+		return deleted.DeletedAt.Before(cutoff)
+	})
+	return nil
+}
+
+func (m *memoryStore) SleepEntries() ([]SleepEntry, error) {
+	entries := make([]SleepEntry, len(m.sleep))
+	copy(entries, m.sleep)
+	return entries, nil
+}
+
+func (m *memoryStore) AddSleep(entry SleepEntry) error {
+	m.sleep = append(m.sleep, entry)
+	return nil
+}
+
+func (m *memoryStore) ArchiveDay(day time.Time, events []CoffeeIntakeEvent) error {
+	m.archive[day.Format("2006-01-02")] = events
+	return nil
+}
+
+func (m *memoryStore) Rollups() ([]DayRollup, error) {
+	return slices.Clone(m.rollups), nil
+}
+
+// SaveRollup keeps the rollups sorted by day.
+func (m *memoryStore) SaveRollup(rollup DayRollup) error {
+	i, found := slices.BinarySearchFunc(m.rollups, rollup.Day, func(r DayRollup, day time.Time) int {
+		return r.Day.Compare(day)
+	})
+	if found {
+		m.rollups[i] = rollup
+	} else {
+		m.rollups = slices.Insert(m.rollups, i, rollup)
+	}
+	return nil
+}
+
+func (m *memoryStore) DeleteRollup(day time.Time) error {
+	m.rollups = slices.DeleteFunc(m.rollups, func(r DayRollup) bool {
+		return r.Day.Equal(day)
+	})
+	return nil
+}
+
+func (m *memoryStore) Scenarios() ([]Scenario, error) {
+	return slices.Clone(m.scenarios), nil
+}
+
+func (m *memoryStore) SaveScenario(scenario Scenario) error {
+	m.DeleteScenario(scenario.Name)
+	m.scenarios = append(m.scenarios, scenario)
+	return nil
+}
+
+func (m *memoryStore) DeleteScenario(name string) (bool, error) {
+	n := len(m.scenarios)
+	m.scenarios = slices.DeleteFunc(m.scenarios, func(s Scenario) bool {
+		return s.Name == name
+	})
+	return len(m.scenarios) < n, nil
+}
+
+func (m *memoryStore) Profiles() ([]string, error) {
+	return slices.Clone(m.profiles), nil
+}
+
+func (m *memoryStore) AddProfile(name string) error {
+	if i, found := slices.BinarySearch(m.profiles, name); !found {
+		m.profiles = slices.Insert(m.profiles, i, name)
+	}
+	return nil
+}
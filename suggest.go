@@ -0,0 +1,128 @@
+package main
+
+import "time"
+
+const (
+	suggestTimeStep   = 15 * time.Minute // Spacing of candidate drink times
+	suggestAmountStep = 25.0             // Spacing of candidate amounts in mg
+	suggestMaxAmount  = 400.0            // Largest amount ever suggested, in mg
+)
+
+// Suggestion is the recommended next drink. Time is nil if no drink is
+// needed or none fits the constraints; Reason explains why.
+type Suggestion struct {
+	Time         *time.Time `json:"time"`
+	Amount       float64    `json:"amount"`
+	Reason       string     `json:"reason"`
+	CoveredPct   float64    `json:"coveredPct"`   // Share of the time until "until" spent at or above the floor
+	BedtimeLevel float64    `json:"bedtimeLevel"` // Level at bedtime including the suggestion
+}
+
+// SuggestDrink searches for the drink that keeps the caffeine level at or
+// above floor from now until until for as long as possible, while leaving
+// the level, scaled by the hourly sensitivity, at or below the sleep
+// threshold at bedtime. Among equally good candidates it prefers the
+// smallest amount, then the latest time.
+//
+// Candidates are every suggestTimeStep from now to until and every
+// suggestAmountStep mg up to suggestMaxAmount. The level without the new
+// drink is computed once per sample; each candidate then only adds its own
+// decay curve.
+func (t *Tracker) SuggestDrink(floor float64, until, bedtime time.Time) Suggestion {
+	events, config := t.snapshot(), t.Config()
+	now := t.clock.Now()
+
+	var samples []time.Time
+	for at := now; !at.After(until); at = at.Add(projectionStep) {
+		samples = append(samples, at)
+	}
+	base := make([]float64, len(samples))
+	for i, at := range samples {
+		base[i] = caffeineLevelAt(events, at, config)
+	}
+	baseBedtime := caffeineLevelAt(events, bedtime, config)
+
+	// contribution is what a drink of amount at drinkAt adds at at
+	contribution := func(amount float64, drinkAt, at time.Time) float64 {
+		return eventLevel(CoffeeIntakeEvent{Time: drinkAt, Amount: amount}, at.Sub(drinkAt).Hours(), config)
+	}
+	covered := func(amount float64, drinkAt time.Time) int {
+		n := 0
+		for i, at := range samples {
+			if base[i]+contribution(amount, drinkAt, at) >= floor {
+				n++
+			}
+		}
+		return n
+	}
+	pct := func(n int) float64 {
+		return float64(n) / float64(len(samples)) * 100
+	}
+
+	baseCovered := covered(0, now)
+	if baseCovered == len(samples) {
+		return Suggestion{Reason: "no drink needed: the level stays at or above the floor", CoveredPct: 100, BedtimeLevel: baseBedtime}
+	}
+
+	best := Suggestion{CoveredPct: pct(baseCovered), BedtimeLevel: baseBedtime}
+	bestCovered := baseCovered
+	fits := false
+	for amount := suggestAmountStep; amount <= suggestMaxAmount; amount += suggestAmountStep {
+		for drinkAt := now; !drinkAt.After(until); drinkAt = drinkAt.Add(suggestTimeStep) {
+			bedtimeLevel := baseBedtime + contribution(amount, drinkAt, bedtime)
+			if bedtimeLevel*config.SensitivityAt(bedtime) > config.SleepThresholdMg {
+				continue
+			}
+			fits = true
+			// Strictly better coverage wins; with equal coverage the
+			// smaller amount already won, and a later time wins
+			n := covered(amount, drinkAt)
+			if n > bestCovered || (n == bestCovered && best.Time != nil && best.Amount == amount) {
+				at := drinkAt
+				best = Suggestion{
+					Time:         &at,
+					Amount:       amount,
+					Reason:       "keeps you above the floor longest without disturbing sleep",
+					CoveredPct:   pct(n),
+					BedtimeLevel: bedtimeLevel,
+				}
+				bestCovered = n
+			}
+		}
+	}
+	if best.Time == nil {
+		best.Reason = "no drink fits: any drink leaves the level above the sleep threshold at bedtime"
+		if fits {
+			best.Reason = "no drink helps: none keeps the level above the floor for longer"
+		}
+	}
+	return best
+}
+
+// TopUp is the drink that brings the caffeine level up to a target.
+type TopUp struct {
+	TargetMg    float64   `json:"targetMg"`
+	CurrentMg   float64   `json:"currentMg"`
+	AmountMg    float64   `json:"amountMg"`    // 0 if the level is already at or above the target
+	ProjectedMg float64   `json:"projectedMg"` // Level once the drink is fully absorbed
+	ProjectedAt time.Time `json:"projectedAt"` // When that is: now with instant absorption
+}
+
+// TopUpTo returns how much to drink now to bring the caffeine level up to
+// target: the difference between target and the current level. With
+// gradual absorption the drink takes a while to peak and earlier drinks
+// keep decaying meanwhile, so the projected level falls somewhat short.
+func (t *Tracker) TopUpTo(target float64) TopUp {
+	events, config := t.snapshot(), t.Config()
+	now := t.clock.Now()
+	topUp := TopUp{TargetMg: target, CurrentMg: caffeineLevelAt(events, now, config), ProjectedAt: now}
+	topUp.AmountMg = max(target-topUp.CurrentMg, 0)
+	if topUp.AmountMg == 0 {
+		topUp.ProjectedMg = topUp.CurrentMg
+		return topUp
+	}
+	drink := CoffeeIntakeEvent{Time: now, Amount: topUp.AmountMg}
+	topUp.ProjectedAt = now.Add(time.Duration(peakHours(drink, config) * float64(time.Hour)))
+	topUp.ProjectedMg = caffeineLevelAt(append(events, drink), topUp.ProjectedAt, config)
+	return topUp
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	tombstoneRetention = 30 * 24 * time.Hour // How long deletions are remembered for syncing clients
+	maxTombstones      = 10000               // Most deletions remembered, however recent
+)
+
+// Tombstone records that an event was deleted.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// Changes is what changed since a client last synced. Clients pass
+// ServerTime as the next since. If Complete is false, deletions from before
+// the retention window may be missing and the client should refetch all
+// events instead.
+type Changes struct {
+	ServerTime time.Time           `json:"serverTime"`
+	Events     []CoffeeIntakeEvent `json:"events"`  // Created or edited after since
+	Deleted    []string            `json:"deleted"` // IDs deleted after since
+	Complete   bool                `json:"complete"`
+}
+
+// ChangesSince returns the events created or edited, and the IDs of events
+// deleted, after since; a zero since returns all events. Events without a modification time count as
+// modified when they were drunk.
+func (t *Tracker) ChangesSince(since time.Time) (Changes, error) {
+	// Read the clock first so nothing changed after it is missed next time
+	changes := Changes{
+		ServerTime: t.clock.Now(),
+		Events:     make([]CoffeeIntakeEvent, 0),
+		Deleted:    make([]string, 0),
+	}
+	present := make(map[string]bool)
+	for _, event := range t.snapshot() {
+		present[event.ID] = true
+		modified := event.ModifiedAt
+		if modified.IsZero() {
+			modified = event.Time
+		}
+		if modified.After(since) {
+			changes.Events = append(changes.Events, event)
+		}
+	}
+
+	t.mu.Lock()
+	tombstones, err := t.store.Tombstones()
+	t.mu.Unlock()
+	if err != nil {
+		return Changes{}, fmt.Errorf("reading tombstones: %w", err)
+	}
+	for _, tombstone := range tombstones {
+		// A restored event is back in the events and no longer deleted
+		if tombstone.DeletedAt.After(since) && !present[tombstone.ID] {
+			changes.Deleted = append(changes.Deleted, tombstone.ID)
+		}
+	}
+
+	// Tombstones older than the window, or pushed out by the count cap,
+	// are gone. Without a since, the events are a full copy anyway.
+	cutoff := changes.ServerTime.Add(-tombstoneRetention)
+	if len(tombstones) >= maxTombstones && tombstones[0].DeletedAt.After(cutoff) {
+		cutoff = tombstones[0].DeletedAt
+	}
+	changes.Complete = since.IsZero() || !since.Before(cutoff)
+	return changes, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Plain-text output is for humans reading curl output: times are rendered
+// on the wall clock of the requested zone. JSON responses never go through
+// these helpers and keep RFC3339 timestamps for machines.
+
+const clockLayout = "3:04 PM" // Layout of times in plain-text output
+
+// wantsText reports whether the client asked for a plain-text response,
+// either with ?format=text or an Accept header preferring text/plain.
+func wantsText(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "text" {
+		return true
+	}
+	return strings.HasPrefix(r.Header.Get("Accept"), "text/plain")
+}
+
+// requestLocation returns the zone for calendar days and plain-text times:
+// ?tz= if given, otherwise the configured timezone.
+func requestLocation(r *http.Request, config Config) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		return config.Location(), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+// formatClock renders t as a wall-clock time in loc, e.g. "3:04 PM".
+func formatClock(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(clockLayout)
+}
+
+// writeText writes body as a plain-text response with the given status code.
+func writeText(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, body)
+}
+
+// nextClockTime returns the next time at or after now when the wall clock
+// in loc shows hhmm, given as "23:00".
+func nextClockTime(hhmm string, now time.Time, loc *time.Location) (time.Time, error) {
+	clock, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want HH:MM", hhmm)
+	}
+	local := now.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	if next.Before(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+// clockTimeOn returns the time on the calendar day of day in loc when the
+// wall clock shows hhmm, given as "14:00".
+func clockTimeOn(hhmm string, day time.Time, loc *time.Location) (time.Time, error) {
+	clock, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want HH:MM", hhmm)
+	}
+	local := day.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// trashSweepInterval is how often deleted events past the restore window
+// are purged.
+const trashSweepInterval = time.Hour
+
+// errNotRestorable is returned when no deleted event with the requested ID
+// can be restored, because there is none or its restore window has passed.
+var errNotRestorable = errors.New("no restorable deleted drink with that ID")
+
+// DeletedEvent is a deleted event kept for RestoreWindowHours so an
+// accidental delete can be undone. It no longer counts anywhere.
+type DeletedEvent struct {
+	CoffeeIntakeEvent
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// RestoreEvent puts a deleted event back if it was deleted within the
+// restore window. It counts as edited now, so syncing clients that saw the
+// deletion pick it up again.
+func (t *Tracker) RestoreEvent(id string) (CoffeeIntakeEvent, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	deleted, found, err := t.store.RemoveTrash(id)
+	if err != nil {
+		return CoffeeIntakeEvent{}, fmt.Errorf("reading deleted drinks: %w", err)
+	}
+	window := time.Duration(t.config.RestoreWindowHours) * time.Hour
+	if !found || deleted.DeletedAt.Before(now.Add(-window)) {
+		return CoffeeIntakeEvent{}, errNotRestorable
+	}
+
+	event := deleted.CoffeeIntakeEvent
+	event.ModifiedAt = now
+	if err := t.store.Add(event); err != nil {
+		if err := t.store.AddTrash(deleted); err != nil {
+			fmt.Printf("Error returning drink %s to the deleted drinks: %v\n", id, err)
+		}
+		return CoffeeIntakeEvent{}, fmt.Errorf("storing drink: %w", err)
+	}
+	t.invalidateRollupLocked(event.Time)
+	t.version++
+	t.notifier.Notify()
+	fmt.Printf("Restored drink %s\n", id)
+	return event, nil
+}
+
+// RunTrashSweep purges deleted events past the restore window on start and
+// then every trashSweepInterval until ctx is done.
+func (t *Tracker) RunTrashSweep(ctx context.Context) {
+	ticker := time.NewTicker(trashSweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := t.sweepTrash(); err != nil {
+			fmt.Printf("Error purging deleted drinks: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepTrash runs one pass of RunTrashSweep.
+func (t *Tracker) sweepTrash() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	window := time.Duration(t.config.RestoreWindowHours) * time.Hour
+	return t.store.TrimTrash(t.clock.Now().Add(-window))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeleteThenRestore(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+	event := mustAdd(t, tracker, clock.Now(), 100)
+
+	if rec := do(handler, http.MethodDelete, "/api/events/"+event.ID, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d: %s", rec.Code, rec.Body)
+	}
+	if events := tracker.GetEvents(); len(events) != 0 {
+		t.Fatalf("deleted drink still listed: %+v", events)
+	}
+	if level := tracker.CalculateCaffeineLevelAt(clock.Now()); level != 0 {
+		t.Errorf("level = %v after deleting the only drink, want 0", level)
+	}
+
+	clock.Advance(23 * time.Hour)
+	if rec := do(handler, http.MethodPost, "/api/events/"+event.ID+"/restore", nil); rec.Code != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", rec.Code, rec.Body)
+	}
+	events := tracker.GetEvents()
+	if len(events) != 1 || events[0].ID != event.ID || !events[0].Time.Equal(event.Time) {
+		t.Fatalf("events after restoring = %+v, want the original drink", events)
+	}
+	if rec := do(handler, http.MethodPost, "/api/events/"+event.ID+"/restore", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("second restore: status %d, want 404", rec.Code)
+	}
+}
+
+func TestDeleteThenPurge(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	handler := newTestServer(t, tracker)
+	purged := mustAdd(t, tracker, clock.Now(), 100)
+	expired := mustAdd(t, tracker, clock.Now(), 50)
+	for _, event := range []CoffeeIntakeEvent{purged, expired} {
+		if err := tracker.DeleteEvent(event.ID); err != nil {
+			t.Fatalf("DeleteEvent: %v", err)
+		}
+	}
+
+	clock.Advance(25 * time.Hour)
+	// Past the window a drink can't be restored, swept or not
+	if rec := do(handler, http.MethodPost, "/api/events/"+expired.ID+"/restore", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("restoring an expired drink: status %d, want 404", rec.Code)
+	}
+	if err := tracker.sweepTrash(); err != nil {
+		t.Fatalf("sweepTrash: %v", err)
+	}
+	if _, err := tracker.RestoreEvent(purged.ID); err != errNotRestorable {
+		t.Errorf("RestoreEvent after the sweep: %v, want errNotRestorable", err)
+	}
+	if events := tracker.GetEvents(); len(events) != 0 {
+		t.Errorf("events = %+v, want none", events)
+	}
+}
+
+func TestZeroRestoreWindowDeletesForGood(t *testing.T) {
+	tracker, clock := newTestTracker(t)
+	config := tracker.Config()
+	config.RestoreWindowHours = 0
+	if err := tracker.SetConfig(config); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	event := mustAdd(t, tracker, clock.Now(), 100)
+	if err := tracker.DeleteEvent(event.ID); err != nil {
+		t.Fatalf("DeleteEvent: %v", err)
+	}
+	if _, err := tracker.RestoreEvent(event.ID); err != errNotRestorable {
+		t.Errorf("RestoreEvent: %v, want errNotRestorable", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// futureCheckInterval is how often the self-check looks for future-dated events.
+const futureCheckInterval = time.Hour
+
+// Issue is a violated invariant of the stored events.
+type Issue struct {
+	Kind     string `json:"kind"`     // "order", "duplicate-id", "missing-id", "amount" or "future"
+	Severity string `json:"severity"` // "error", or "warning" for things that may be intended
+	Index    int    `json:"index"`    // Position of the event in store order
+	EventID  string `json:"eventId,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Verify checks the stored events for broken invariants and reports every
+// violation it finds. It never modifies data. Drinks logged for later are
+// legitimate, so future timestamps are only warnings.
+func (t *Tracker) Verify() ([]Issue, int, error) {
+	t.mu.Lock()
+	events, err := t.store.Events()
+	t.mu.Unlock()
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading events: %w", err)
+	}
+	now := t.clock.Now()
+
+	issues := make([]Issue, 0)
+	report := func(kind, severity string, i int, format string, args ...any) {
+		issues = append(issues, Issue{
+			Kind:     kind,
+			Severity: severity,
+			Index:    i,
+			EventID:  events[i].ID,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	firstIndex := make(map[string]int, len(events))
+	for i, event := range events {
+		if i > 0 && event.Time.Before(events[i-1].Time) {
+			report("order", "error", i, "logged at %s, before the previous event at %s", event.Time.Format(time.RFC3339), events[i-1].Time.Format(time.RFC3339))
+		}
+		if event.ID == "" {
+			report("missing-id", "error", i, "event has no ID")
+		} else if first, ok := firstIndex[event.ID]; ok {
+			report("duplicate-id", "error", i, "ID already used by the event at index %d", first)
+		} else {
+			firstIndex[event.ID] = i
+		}
+		if math.IsNaN(event.Amount) || math.IsInf(event.Amount, 0) {
+			report("amount", "error", i, "amount %v is not a number", event.Amount)
+		} else if event.Amount <= 0 && !event.Override {
+			report("amount", "error", i, "amount %v is not a positive number", event.Amount)
+		}
+		if event.Time.After(now) {
+			report("future", "warning", i, "logged for %s, which is in the future", event.Time.Format(time.RFC3339))
+		}
+	}
+	return issues, len(events), nil
+}
+
+// RunFutureCheck looks for future-dated events on start and then every
+// futureCheckInterval until ctx is done. They contribute nothing to the
+// level until their time comes, which is intended for drinks logged for
+// later but can also be the result of clock skew or a bad import, so each
+// one found is logged as a warning and counted for /healthz. With clamp set
+// they are moved to the current time instead.
+func (t *Tracker) RunFutureCheck(ctx context.Context, clamp bool) {
+	ticker := time.NewTicker(futureCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := t.checkFutureEvents(clamp); err != nil {
+			fmt.Printf("Error checking for future events: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkFutureEvents runs one pass of RunFutureCheck.
+func (t *Tracker) checkFutureEvents(clamp bool) error {
+	now := t.clock.Now()
+	future := 0
+	for _, event := range t.eventsSince(now) {
+		if !event.Time.After(now) {
+			continue
+		}
+		if !clamp {
+			fmt.Printf("Warning: drink %s is logged for %s, in the future\n", event.ID, event.Time.Format(time.RFC3339))
+			future++
+			continue
+		}
+		fmt.Printf("Moving drink %s from %s to now\n", event.ID, event.Time.Format(time.RFC3339))
+		event.Time = now
+		if _, err := t.UpdateEvent(event); err != nil && !errors.Is(err, errEventNotFound) {
+			t.futureEvents.Store(int64(future))
+			return err
+		}
+	}
+	t.futureEvents.Store(int64(future))
+	return nil
+}
+
+// FutureEvents returns the number of future-dated events found by the last
+// self-check.
+func (t *Tracker) FutureEvents() int {
+	return int(t.futureEvents.Load())
+}